@@ -0,0 +1,677 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	analyticsSinkElastic = "elastic"
+	analyticsSinkMemory  = "memory"
+
+	searchEventsIndexName   = "search_events"
+	feedbackEventsIndexName = "feedback_events"
+	memoryAnalyticsMaxLen   = 100000
+	feedbackMaxSearches     = 10000
+	experimentMaxVariants   = 100
+
+	// searches wait for the analytics workers in the queue,
+	// searches are not recorded while it's full
+	analyticsQueueLen = 1000
+	analyticsWorkers  = 4
+
+	searchEventsMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"time":{
+				"type":"date"
+			},
+			"collection":{
+				"type":"keyword"
+			},
+			"query":{
+				"type":"keyword"
+			},
+			"filter":{
+				"type":"keyword"
+			},
+			"filters":{
+				"type":"keyword"
+			},
+			"profile":{
+				"type":"keyword"
+			},
+			"result_count":{
+				"type":"long"
+			},
+			"latency_ms":{
+				"type":"long"
+			},
+			"source":{
+				"type":"keyword"
+			},
+			"offset":{
+				"type":"long"
+			},
+			"results":{
+				"type":"keyword"
+			},
+			"experiment":{
+				"type":"keyword"
+			},
+			"variant":{
+				"type":"keyword"
+			}
+		}
+	}
+}
+`
+
+	feedbackEventsMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"time":{
+				"type":"date"
+			},
+			"collection":{
+				"type":"keyword"
+			},
+			"type":{
+				"type":"keyword"
+			},
+			"search_id":{
+				"type":"keyword"
+			},
+			"product_id":{
+				"type":"keyword"
+			},
+			"query":{
+				"type":"keyword"
+			},
+			"position":{
+				"type":"long"
+			},
+			"source":{
+				"type":"keyword"
+			},
+			"experiment":{
+				"type":"keyword"
+			},
+			"variant":{
+				"type":"keyword"
+			}
+		}
+	}
+}
+`
+)
+
+// SearchEvent describes one search made through the API in the collection
+type SearchEvent struct {
+	ID          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	Collection  string    `json:"collection"`
+	Query       string    `json:"query"`
+	Filter      string    `json:"filter,omitempty"`
+	Filters     []string  `json:"filters,omitempty"`
+	Profile     string    `json:"profile,omitempty"`
+	ResultCount int64     `json:"result_count"`
+	LatencyMs   int64     `json:"latency_ms"`
+	Source      string    `json:"source"`
+	Offset      int       `json:"offset"`
+	Results     []string  `json:"results"`
+	Experiment  string    `json:"experiment,omitempty"`
+	Variant     string    `json:"variant,omitempty"`
+}
+
+// QueryStat is a row of the analytics report
+type QueryStat struct {
+	Query string `json:"query"`
+	Count int64  `json:"count"`
+}
+
+// analyticsSink records searches and feedback on them and builds reports,
+// reports skip empty queries. Reports are built for events of the collection,
+// empty collection means events of all collections
+type analyticsSink interface {
+	recordSearch(event SearchEvent) error
+	search(id string) (SearchEvent, error)
+	topQueries(collection string, from, to time.Time, limit int) ([]QueryStat, error)
+	zeroResults(collection string, from, to time.Time, limit int) ([]QueryStat, error)
+
+	recordFeedback(event FeedbackEvent) error
+	feedbackReport(collection string, from, to time.Time, limit int) ([]QueryFeedback, error)
+	experimentReport(collection, name string, from, to time.Time) ([]VariantFeedback, error)
+}
+
+// eventCollection returns the collection of the event, events recorded
+// before collections have no collection and belong to the default one
+func eventCollection(collection string) string {
+	if collection == "" {
+		return defaultCollectionName
+	}
+
+	return collection
+}
+
+// reported checks the event of the collection is in the report of the collection
+func reported(event, collection string) bool {
+	return collection == "" || eventCollection(event) == collection
+}
+
+// filterCollection adds the filter of events of the collection to the query
+func filterCollection(query *elastic.BoolQuery, collection string) *elastic.BoolQuery {
+	if collection == "" {
+		return query
+	}
+
+	inCollection := elastic.NewBoolQuery().
+		Should(elastic.NewTermQuery("collection", collection))
+	if collection == defaultCollectionName {
+		inCollection.Should(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("collection")))
+	}
+
+	return query.Filter(inCollection)
+}
+
+func newAnalyticsSink(kind string, engine elasticEngine) (analyticsSink, error) {
+	switch kind {
+	case "", analyticsSinkMemory:
+		return newMemoryAnalytics(), nil
+	case analyticsSinkElastic:
+		return newElasticAnalytics(engine)
+	default:
+		return nil, fmt.Errorf("unknown analytics sink %q", kind)
+	}
+}
+
+var errAnalyticsQueueFull = errors.New("analytics queue is full")
+
+// queuedAnalytics records searches to the sink in background by the fixed number of
+// workers, so the sink latency doesn't affect the search. Queued searches are found by
+// id until they are recorded, so feedback can be sent right after the search. Queued
+// searches are recorded on close, other methods go to the sink directly
+type queuedAnalytics struct {
+	analyticsSink
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan SearchEvent
+	wg     sync.WaitGroup
+
+	pendingMu sync.Mutex
+	pending   map[string]SearchEvent
+}
+
+func newQueuedAnalytics(sink analyticsSink) *queuedAnalytics {
+	qa := &queuedAnalytics{
+		analyticsSink: sink,
+		queue:         make(chan SearchEvent, analyticsQueueLen),
+		pending:       map[string]SearchEvent{},
+	}
+
+	qa.wg.Add(analyticsWorkers)
+	for i := 0; i < analyticsWorkers; i++ {
+		go qa.run()
+	}
+
+	return qa
+}
+
+// recordSearch queues the search without waiting, the search is dropped
+// when the queue is full or closed
+func (qa *queuedAnalytics) recordSearch(event SearchEvent) error {
+	qa.mu.RLock()
+	defer qa.mu.RUnlock()
+
+	if qa.closed {
+		return errAnalyticsQueueFull
+	}
+
+	qa.setPending(event)
+	select {
+	case qa.queue <- event:
+		return nil
+	default:
+		qa.deletePending(event.ID)
+		return errAnalyticsQueueFull
+	}
+}
+
+// search returns the queued search or the search recorded to the sink
+func (qa *queuedAnalytics) search(id string) (SearchEvent, error) {
+	qa.pendingMu.Lock()
+	event, ok := qa.pending[id]
+	qa.pendingMu.Unlock()
+	if ok {
+		return event, nil
+	}
+
+	return qa.analyticsSink.search(id)
+}
+
+func (qa *queuedAnalytics) setPending(event SearchEvent) {
+	qa.pendingMu.Lock()
+	defer qa.pendingMu.Unlock()
+
+	qa.pending[event.ID] = event
+}
+
+func (qa *queuedAnalytics) deletePending(id string) {
+	qa.pendingMu.Lock()
+	defer qa.pendingMu.Unlock()
+
+	delete(qa.pending, id)
+}
+
+func (qa *queuedAnalytics) run() {
+	defer qa.wg.Done()
+
+	for event := range qa.queue {
+		if err := qa.analyticsSink.recordSearch(event); err != nil {
+			log.Errorf("error while record search: %v", err)
+		}
+
+		qa.deletePending(event.ID)
+	}
+}
+
+// close stops accepting searches and waits until the queued ones are recorded
+func (qa *queuedAnalytics) close() {
+	qa.mu.Lock()
+	if !qa.closed {
+		qa.closed = true
+		close(qa.queue)
+	}
+	qa.mu.Unlock()
+
+	qa.wg.Wait()
+}
+
+// memoryAnalytics keeps the latest searches in memory, it's lost on restart
+// and intended for tests and single instance setups
+type memoryAnalytics struct {
+	mu       sync.RWMutex
+	events   []SearchEvent
+	feedback []FeedbackEvent
+}
+
+func newMemoryAnalytics() *memoryAnalytics {
+	return &memoryAnalytics{}
+}
+
+func (ma *memoryAnalytics) recordSearch(event SearchEvent) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.events = append(ma.events, event)
+	if len(ma.events) > memoryAnalyticsMaxLen {
+		ma.events = ma.events[len(ma.events)-memoryAnalyticsMaxLen:]
+	}
+
+	return nil
+}
+
+func (ma *memoryAnalytics) search(id string) (SearchEvent, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	for i := len(ma.events) - 1; i >= 0; i-- {
+		if ma.events[i].ID == id {
+			return ma.events[i], nil
+		}
+	}
+
+	return SearchEvent{}, errNotFound
+}
+
+func (ma *memoryAnalytics) recordFeedback(event FeedbackEvent) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.feedback = append(ma.feedback, event)
+	if len(ma.feedback) > memoryAnalyticsMaxLen {
+		ma.feedback = ma.feedback[len(ma.feedback)-memoryAnalyticsMaxLen:]
+	}
+
+	return nil
+}
+
+func (ma *memoryAnalytics) feedbackReport(collection string, from, to time.Time, limit int) ([]QueryFeedback, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	counts := newFeedbackCounts()
+	for _, event := range ma.events {
+		if event.Query == "" || event.Time.Before(from) || event.Time.After(to) || !reported(event.Collection, collection) {
+			continue
+		}
+
+		counts.searches[event.Query]++
+	}
+
+	for _, event := range ma.feedback {
+		if event.Query == "" || event.Time.Before(from) || event.Time.After(to) || !reported(event.Collection, collection) {
+			continue
+		}
+
+		counts.addEvent(event.Query, event.Type, 1)
+		if event.Type == feedbackClick && event.Position > 0 {
+			counts.addClick(event.Query, event.SearchID, event.Position)
+		}
+	}
+
+	return counts.report(limit), nil
+}
+
+func (ma *memoryAnalytics) experimentReport(collection, name string, from, to time.Time) ([]VariantFeedback, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	counts := newFeedbackCounts()
+	for _, event := range ma.events {
+		if event.Experiment != name || event.Time.Before(from) || event.Time.After(to) || !reported(event.Collection, collection) {
+			continue
+		}
+
+		counts.searches[event.Variant]++
+	}
+
+	for _, event := range ma.feedback {
+		if event.Experiment != name || event.Time.Before(from) || event.Time.After(to) || !reported(event.Collection, collection) {
+			continue
+		}
+
+		counts.addEvent(event.Variant, event.Type, 1)
+		if event.Type == feedbackClick && event.Position > 0 {
+			counts.addClick(event.Variant, event.SearchID, event.Position)
+		}
+	}
+
+	return variantReport(counts.report(experimentMaxVariants)), nil
+}
+
+func (ma *memoryAnalytics) topQueries(collection string, from, to time.Time, limit int) ([]QueryStat, error) {
+	return ma.count(collection, from, to, limit, func(SearchEvent) bool { return true }), nil
+}
+
+func (ma *memoryAnalytics) zeroResults(collection string, from, to time.Time, limit int) ([]QueryStat, error) {
+	return ma.count(collection, from, to, limit, func(event SearchEvent) bool { return event.ResultCount == 0 }), nil
+}
+
+func (ma *memoryAnalytics) count(collection string, from, to time.Time, limit int, match func(SearchEvent) bool) []QueryStat {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, event := range ma.events {
+		if event.Query == "" || event.Time.Before(from) || event.Time.After(to) || !reported(event.Collection, collection) || !match(event) {
+			continue
+		}
+
+		counts[event.Query]++
+	}
+
+	return topStats(counts, limit)
+}
+
+// topStats orders queries by count, queries with equal count are ordered by name
+func topStats(counts map[string]int64, limit int) []QueryStat {
+	stats := make([]QueryStat, 0, len(counts))
+	for query, count := range counts {
+		stats = append(stats, QueryStat{Query: query, Count: count})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count == stats[j].Count {
+			return stats[i].Query < stats[j].Query
+		}
+
+		return stats[i].Count > stats[j].Count
+	})
+
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+
+	return stats
+}
+
+// elasticAnalytics stores searches in the separate elastic index
+type elasticAnalytics struct {
+	client *elastic.Client
+}
+
+func newElasticAnalytics(engine elasticEngine) (*elasticAnalytics, error) {
+	if err := engine.ensureIndex(searchEventsIndexName, searchEventsMapping); err != nil {
+		return nil, wrapper.Wrap(err, "error while create search events index")
+	}
+
+	if err := engine.ensureIndex(feedbackEventsIndexName, feedbackEventsMapping); err != nil {
+		return nil, wrapper.Wrap(err, "error while create feedback events index")
+	}
+
+	return &elasticAnalytics{client: engine.client}, nil
+}
+
+func (ea *elasticAnalytics) recordSearch(event SearchEvent) error {
+	_, err := ea.client.Index().
+		Index(searchEventsIndexName).
+		Id(event.ID).
+		BodyJson(event).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while record search event")
+	}
+
+	return nil
+}
+
+func (ea *elasticAnalytics) search(id string) (SearchEvent, error) {
+	result, err := ea.client.Get().
+		Index(searchEventsIndexName).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return SearchEvent{}, errNotFound
+	}
+	if err != nil {
+		return SearchEvent{}, wrapper.Wrap(err, "error while get search event")
+	}
+
+	event := SearchEvent{}
+	if err := json.Unmarshal(result.Source, &event); err != nil {
+		return SearchEvent{}, wrapper.Wrap(err, "error while unmarshal search event")
+	}
+
+	return event, nil
+}
+
+func (ea *elasticAnalytics) recordFeedback(event FeedbackEvent) error {
+	_, err := ea.client.Index().
+		Index(feedbackEventsIndexName).
+		Id(event.ID).
+		BodyJson(event).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while record feedback event")
+	}
+
+	return nil
+}
+
+// feedbackReport takes the most searched queries and aggregates feedback
+// events of these queries by type and by search
+func (ea *elasticAnalytics) feedbackReport(collection string, from, to time.Time, limit int) ([]QueryFeedback, error) {
+	top, err := ea.count(collection, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := newFeedbackCounts()
+	queries := make([]interface{}, 0, len(top))
+	for _, stat := range top {
+		counts.searches[stat.Query] = stat.Count
+		queries = append(queries, stat.Query)
+	}
+
+	if len(queries) == 0 {
+		return counts.report(limit), nil
+	}
+
+	filter := filterCollection(elastic.NewBoolQuery().Filter(elastic.NewTermsQuery("query", queries...)), collection)
+	if err := ea.collectFeedback(counts, "query", filter, from, to, len(queries)); err != nil {
+		return nil, err
+	}
+
+	return counts.report(limit), nil
+}
+
+func (ea *elasticAnalytics) experimentReport(collection, name string, from, to time.Time) ([]VariantFeedback, error) {
+	filter := func() *elastic.BoolQuery {
+		return filterCollection(elastic.NewBoolQuery().Filter(elastic.NewTermQuery("experiment", name)), collection)
+	}
+
+	variants, err := ea.countBy("variant", filter(), from, to, experimentMaxVariants)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := newFeedbackCounts()
+	for _, stat := range variants {
+		counts.searches[stat.Query] = stat.Count
+	}
+
+	if err := ea.collectFeedback(counts, "variant", filter(), from, to, experimentMaxVariants); err != nil {
+		return nil, err
+	}
+
+	return variantReport(counts.report(experimentMaxVariants)), nil
+}
+
+// collectFeedback aggregates feedback events grouped by the field into counts
+func (ea *elasticAnalytics) collectFeedback(counts feedbackCounts, field string, filter *elastic.BoolQuery, from, to time.Time, size int) error {
+	clicks := elastic.NewFilterAggregation().
+		Filter(elastic.NewBoolQuery().
+			Filter(elastic.NewTermQuery("type", feedbackClick)).
+			Filter(elastic.NewRangeQuery("position").Gte(1))).
+		SubAggregation("searches", elastic.NewTermsAggregation().
+			Field("search_id").
+			Size(feedbackMaxSearches).
+			SubAggregation("position", elastic.NewMinAggregation().Field("position")))
+
+	byKey := elastic.NewTermsAggregation().
+		Field(field).
+		Size(size).
+		SubAggregation("types", elastic.NewTermsAggregation().Field("type")).
+		SubAggregation("clicks", clicks)
+
+	result, err := ea.client.Search().
+		Index(feedbackEventsIndexName).
+		Query(filter.Filter(elastic.NewRangeQuery("time").Gte(from).Lte(to))).
+		Size(0).
+		Aggregation("keys", byKey).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while aggregate feedback events")
+	}
+
+	keyBuckets, ok := result.Aggregations.Terms("keys")
+	if !ok {
+		return nil
+	}
+
+	for _, keyBucket := range keyBuckets.Buckets {
+		key := fmt.Sprint(keyBucket.Key)
+
+		if types, ok := keyBucket.Terms("types"); ok {
+			for _, typeBucket := range types.Buckets {
+				counts.addEvent(key, fmt.Sprint(typeBucket.Key), typeBucket.DocCount)
+			}
+		}
+
+		clickFilter, ok := keyBucket.Filter("clicks")
+		if !ok {
+			continue
+		}
+
+		searches, ok := clickFilter.Terms("searches")
+		if !ok {
+			continue
+		}
+
+		for _, searchBucket := range searches.Buckets {
+			if min, ok := searchBucket.Min("position"); ok && min.Value != nil {
+				counts.addClick(key, fmt.Sprint(searchBucket.Key), int(*min.Value))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ea *elasticAnalytics) topQueries(collection string, from, to time.Time, limit int) ([]QueryStat, error) {
+	return ea.count(collection, from, to, limit)
+}
+
+func (ea *elasticAnalytics) zeroResults(collection string, from, to time.Time, limit int) ([]QueryStat, error) {
+	return ea.count(collection, from, to, limit, elastic.NewTermQuery("result_count", 0))
+}
+
+func (ea *elasticAnalytics) count(collection string, from, to time.Time, limit int, filters ...elastic.Query) ([]QueryStat, error) {
+	query := elastic.NewBoolQuery().
+		Filter(filters...).
+		MustNot(elastic.NewTermQuery("query", ""))
+
+	return ea.countBy("query", filterCollection(query, collection), from, to, limit)
+}
+
+// countBy counts search events in the time window grouped by the field
+func (ea *elasticAnalytics) countBy(field string, query *elastic.BoolQuery, from, to time.Time, limit int) ([]QueryStat, error) {
+	result, err := ea.client.Search().
+		Index(searchEventsIndexName).
+		Query(query.Filter(elastic.NewRangeQuery("time").Gte(from).Lte(to))).
+		Size(0).
+		Aggregation("keys", elastic.NewTermsAggregation().Field(field).Size(limit)).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while count search events")
+	}
+
+	stats := []QueryStat{}
+	keys, ok := result.Aggregations.Terms("keys")
+	if !ok {
+		return stats, nil
+	}
+
+	for _, bucket := range keys.Buckets {
+		stats = append(stats, QueryStat{Query: fmt.Sprint(bucket.Key), Count: bucket.DocCount})
+	}
+
+	return stats, nil
+}
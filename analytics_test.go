@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryAnalytics(t *testing.T) {
+	Convey("Check memory analytics reports", t, func() {
+		sink := newMemoryAnalytics()
+		now := time.Now().UTC()
+
+		for _, event := range []SearchEvent{
+			SearchEvent{Time: now, Query: "shoe", ResultCount: 3},
+			SearchEvent{Time: now, Query: "shoe", ResultCount: 3},
+			SearchEvent{Time: now, Query: "boot", ResultCount: 0},
+			SearchEvent{Time: now, Query: "", ResultCount: 10},
+			SearchEvent{Time: now.Add(-48 * time.Hour), Query: "sandal", ResultCount: 0},
+		} {
+			if err := sink.recordSearch(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		top, err := sink.topQueries("", now.Add(-time.Hour), now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(top, ShouldResemble, []QueryStat{
+			QueryStat{Query: "shoe", Count: 2},
+			QueryStat{Query: "boot", Count: 1},
+		})
+
+		zero, err := sink.zeroResults("", now.Add(-72*time.Hour), now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(zero, ShouldResemble, []QueryStat{
+			QueryStat{Query: "boot", Count: 1},
+			QueryStat{Query: "sandal", Count: 1},
+		})
+
+		top, err = sink.topQueries("", now.Add(-time.Hour), now, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(top), ShouldEqual, 1)
+
+		// events recorded before collections belong to the default collection
+		So(sink.recordSearch(SearchEvent{Time: now, Collection: "acme", Query: "heel", ResultCount: 1}), ShouldBeNil)
+		top, err = sink.topQueries("acme", now.Add(-time.Hour), now, 10)
+		So(err, ShouldBeNil)
+		So(top, ShouldResemble, []QueryStat{{Query: "heel", Count: 1}})
+
+		top, err = sink.topQueries(defaultCollectionName, now.Add(-time.Hour), now, 10)
+		So(err, ShouldBeNil)
+		So(len(top), ShouldEqual, 2)
+	})
+}
+
+func TestQueuedAnalytics(t *testing.T) {
+	Convey("Check queued searches are recorded on close", t, func() {
+		sink := newMemoryAnalytics()
+		queue := newQueuedAnalytics(sink)
+
+		for i := 0; i < 10; i++ {
+			if err := queue.recordSearch(SearchEvent{ID: fmt.Sprint(i), Time: time.Now().UTC(), Query: "shoe"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		queue.close()
+		So(len(sink.events), ShouldEqual, 10)
+		So(queue.recordSearch(SearchEvent{ID: "late"}), ShouldEqual, errAnalyticsQueueFull)
+	})
+}
+
+func TestSearchFilters(t *testing.T) {
+	Convey("Check all search filters are described", t, func() {
+		priceMin := 10.5
+		inStock := true
+		params := queryParams{
+			filterField: "brand",
+			filterValue: "nike",
+			attributes:  []attributeFilter{attributeFilter{name: "color", value: "red"}},
+			priceMin:    &priceMin,
+			category:    "shoes > running",
+			inStock:     &inStock,
+		}
+
+		So(params.filters(), ShouldResemble, []string{
+			"filter=brand:nike",
+			"attr=color:red",
+			"price_min=10.5",
+			"category=shoes > running",
+			"in_stock=true",
+		})
+	})
+}
+
+// blockingAnalytics records searches only after release is closed
+type blockingAnalytics struct {
+	*memoryAnalytics
+	release chan bool
+}
+
+func (ba blockingAnalytics) recordSearch(event SearchEvent) error {
+	<-ba.release
+	return ba.memoryAnalytics.recordSearch(event)
+}
+
+func TestQueuedAnalyticsPending(t *testing.T) {
+	Convey("Check queued search is found before it's recorded", t, func() {
+		sink := blockingAnalytics{memoryAnalytics: newMemoryAnalytics(), release: make(chan bool)}
+		queue := newQueuedAnalytics(sink)
+
+		if err := queue.recordSearch(SearchEvent{ID: "search", Query: "shoe"}); err != nil {
+			t.Fatal(err)
+		}
+
+		event, err := queue.search("search")
+		So(err, ShouldBeNil)
+		So(event.Query, ShouldEqual, "shoe")
+
+		close(sink.release)
+		queue.close()
+
+		event, err = queue.search("search")
+		So(err, ShouldBeNil)
+		So(event.Query, ShouldEqual, "shoe")
+
+		_, err = queue.search("unknown")
+		So(err, ShouldEqual, errNotFound)
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	apiKeysIndexName = "api_keys"
+	apiKeysMaxCount  = 1000
+
+	apiKeyHeader = "X-API-Key"
+	// apiKeyPrefix marks our keys, so they can be found by secret scanners
+	apiKeyPrefix = "sak_"
+	// apiKeyShownLen is the length of the key start shown in lists to recognize the key
+	apiKeyShownLen = len(apiKeyPrefix) + 8
+	// apiKeyUseInterval limits writes of the last used time to one per interval
+	apiKeyUseInterval = time.Minute
+	// apiKeysCacheTTL is how long keys are read from the cache on authentication,
+	// keys deleted on other instances keep working there at most that long
+	apiKeysCacheTTL = 10 * time.Second
+
+	apiKeysMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"name":{
+				"type":"keyword"
+			},
+			"prefix":{
+				"type":"keyword"
+			},
+			"key_hash":{
+				"type":"keyword"
+			},
+			"tenant":{
+				"type":"keyword"
+			},
+			"scopes":{
+				"type":"keyword"
+			},
+			"expires_at":{
+				"type":"date"
+			},
+			"last_used_at":{
+				"type":"date"
+			},
+			"created_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+// APIKey is a long-lived key of batch jobs which can't refresh tokens, the key is
+// shown only once on create, the registry keeps only its hash. Nil expiry means
+// the key doesn't expire
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"key_hash,omitempty"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyCredentials is the response of the key create
+type APIKeyCredentials struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func (k *APIKey) validate() error {
+	if k.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now()) {
+		return errors.New("expires_at should be in the future")
+	}
+
+	return validateScopes(k.Scopes)
+}
+
+func (k *APIKey) expired() bool {
+	return k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now())
+}
+
+// claims makes token claims of the key, so routes check scopes and tenant of
+// keys and tokens in the same way
+func (k *APIKey) claims() tokenClaims {
+	claims := tokenClaims{
+		StandardClaims: &jwt.StandardClaims{Id: k.ID},
+		TokenType:      tokenType,
+		Info:           tokenInfo{Source: k.ID, Tenant: k.Tenant, Scopes: k.Scopes},
+	}
+
+	if k.ExpiresAt != nil {
+		claims.ExpiresAt = k.ExpiresAt.Unix()
+	}
+
+	return claims
+}
+
+// newAPIKey generates the random prefixed key with its hash
+func newAPIKey() (string, string, error) {
+	secret, _, err := newClientSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := apiKeyPrefix + secret
+	return key, hashSecret(key), nil
+}
+
+// createAPIKey registers the key, keys without scopes have default ones
+func (ee *elasticEngine) createAPIKey(k APIKey) (APIKeyCredentials, error) {
+	key, hash, err := newAPIKey()
+	if err != nil {
+		return APIKeyCredentials{}, err
+	}
+
+	k.ID = uuid.New().String()
+	k.Prefix = key[:apiKeyShownLen]
+	k.KeyHash = hash
+	k.LastUsedAt = nil
+	k.CreatedAt = time.Now().UTC()
+	if len(k.Scopes) == 0 {
+		k.Scopes = defaultScopes
+	}
+
+	if err := ee.putAPIKey(k); err != nil {
+		return APIKeyCredentials{}, err
+	}
+
+	return APIKeyCredentials{APIKey: k, Key: key}, nil
+}
+
+// authenticateAPIKey returns the not expired key and records it's used,
+// keys are random, so they are found by the hash. Keys are cached by the hash
+// for apiKeysCacheTTL, so batch jobs don't search the registry on every request
+func (ee *elasticEngine) authenticateAPIKey(key string) (APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return APIKey{}, errBadCredentials
+	}
+
+	hash := hashSecret(key)
+	k, err := ee.cachedAPIKey(hash)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if k.expired() {
+		return APIKey{}, errBadCredentials
+	}
+
+	now := time.Now().UTC()
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > apiKeyUseInterval {
+		k.LastUsedAt = &now
+		ee.apiKeysCache.set(hash, k)
+		if err := ee.useAPIKey(k.ID, now); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return k, nil
+}
+
+// cachedAPIKey returns the key with the hash read at most apiKeysCacheTTL ago
+func (ee *elasticEngine) cachedAPIKey(hash string) (APIKey, error) {
+	if cached, ok := ee.apiKeysCache.get(hash); ok {
+		return cached.(APIKey), nil
+	}
+
+	result, err := ee.client.Search().
+		Index(apiKeysIndexName).
+		Query(elastic.NewTermQuery("key_hash", hash)).
+		Size(1).
+		Do(context.Background())
+	if err != nil {
+		return APIKey{}, wrapper.Wrap(err, "error while find api key")
+	}
+
+	if len(result.Hits.Hits) == 0 {
+		return APIKey{}, errBadCredentials
+	}
+
+	k := APIKey{}
+	if err := json.Unmarshal(result.Hits.Hits[0].Source, &k); err != nil {
+		return APIKey{}, wrapper.Wrap(err, "error while unmarshal api key")
+	}
+
+	ee.apiKeysCache.set(hash, k)
+	return k, nil
+}
+
+func (ee *elasticEngine) useAPIKey(id string, usedAt time.Time) error {
+	_, err := ee.client.Update().
+		Index(apiKeysIndexName).
+		Id(id).
+		Doc(map[string]interface{}{"last_used_at": usedAt}).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while update api key last used time")
+	}
+
+	return nil
+}
+
+func (ee *elasticEngine) putAPIKey(k APIKey) error {
+	_, err := ee.client.Index().
+		Index(apiKeysIndexName).
+		Id(k.ID).
+		BodyJson(k).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while put api key")
+	}
+
+	return nil
+}
+
+func (ee *elasticEngine) apiKeys() ([]APIKey, error) {
+	result, err := ee.client.Search().
+		Index(apiKeysIndexName).
+		Query(elastic.NewMatchAllQuery()).
+		SortBy(elastic.NewFieldSort("name")).
+		Size(apiKeysMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get api keys")
+	}
+
+	keys := []APIKey{}
+	for _, hit := range result.Hits.Hits {
+		k := APIKey{}
+		if err := json.Unmarshal(hit.Source, &k); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal api key")
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (ee *elasticEngine) getAPIKey(id string) (APIKey, error) {
+	result, err := ee.client.Get().
+		Index(apiKeysIndexName).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return APIKey{}, errNotFound
+	}
+	if err != nil {
+		return APIKey{}, wrapper.Wrap(err, "error while get api key")
+	}
+
+	k := APIKey{}
+	if err := json.Unmarshal(result.Source, &k); err != nil {
+		return APIKey{}, wrapper.Wrap(err, "error while unmarshal api key")
+	}
+
+	return k, nil
+}
+
+// deleteAPIKey revokes the key, it stops working immediately on this instance
+// and after apiKeysCacheTTL on others
+func (ee *elasticEngine) deleteAPIKey(k APIKey) error {
+	_, err := ee.client.Delete().
+		Index(apiKeysIndexName).
+		Id(k.ID).
+		Refresh("true").
+		Do(context.Background())
+	ee.apiKeysCache.delete(k.KeyHash)
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete api key")
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	Convey("Check api keys are prefixed and stored hashed", t, func() {
+		key, hash, err := newAPIKey()
+		So(err, ShouldBeNil)
+		So(strings.HasPrefix(key, apiKeyPrefix), ShouldBeTrue)
+		So(len(key), ShouldEqual, len(apiKeyPrefix)+clientSecretBytes*2)
+		So(hash, ShouldEqual, hashSecret(key))
+		So(hash, ShouldNotContainSubstring, key[len(apiKeyPrefix):])
+
+		other, _, err := newAPIKey()
+		So(err, ShouldBeNil)
+		So(other, ShouldNotEqual, key)
+	})
+}
+
+func TestAPIKeyValidate(t *testing.T) {
+	Convey("Check api key validation and expiry", t, func() {
+		past := time.Now().Add(-time.Hour)
+		future := time.Now().Add(time.Hour)
+
+		So((&APIKey{Name: "nightly-import", Scopes: []string{scopeProductsWrite}}).validate(), ShouldBeNil)
+		So((&APIKey{Scopes: []string{scopeProductsWrite}}).validate(), ShouldNotBeNil)
+		So((&APIKey{Name: "nightly-import", Scopes: []string{"products:delete"}}).validate(), ShouldNotBeNil)
+		So((&APIKey{Name: "nightly-import", ExpiresAt: &past}).validate(), ShouldNotBeNil)
+
+		So((&APIKey{}).expired(), ShouldBeFalse)
+		So((&APIKey{ExpiresAt: &future}).expired(), ShouldBeFalse)
+		So((&APIKey{ExpiresAt: &past}).expired(), ShouldBeTrue)
+	})
+}
+
+func TestAPIKeyClaims(t *testing.T) {
+	Convey("Check api key gives scopes and tenant like the token", t, func() {
+		expiresAt := time.Now().Add(time.Hour)
+		k := APIKey{ID: "key-id", Tenant: "shoes-shop", Scopes: []string{scopeProductsWrite}, ExpiresAt: &expiresAt}
+
+		claims := k.claims()
+		So(claims.Info.Source, ShouldEqual, "key-id")
+		So(claims.Info.Tenant, ShouldEqual, "shoes-shop")
+		So(claims.ExpiresAt, ShouldEqual, expiresAt.Unix())
+		So(claims.hasScope(scopeProductsWrite), ShouldBeTrue)
+		So(claims.hasScope(scopeAdmin), ShouldBeFalse)
+	})
+}
+
+func TestAuthenticateCachedAPIKey(t *testing.T) {
+	Convey("Check api keys are authenticated from the cache", t, func() {
+		ee := elasticEngine{apiKeysCache: newTTLCache(time.Minute)}
+		key, hash, err := newAPIKey()
+		So(err, ShouldBeNil)
+
+		usedAt := time.Now().UTC()
+		ee.apiKeysCache.set(hash, APIKey{ID: "key-id", KeyHash: hash, LastUsedAt: &usedAt})
+
+		k, err := ee.authenticateAPIKey(key)
+		So(err, ShouldBeNil)
+		So(k.ID, ShouldEqual, "key-id")
+
+		past := time.Now().Add(-time.Hour)
+		ee.apiKeysCache.set(hash, APIKey{ID: "key-id", KeyHash: hash, LastUsedAt: &usedAt, ExpiresAt: &past})
+		_, err = ee.authenticateAPIKey(key)
+		So(err, ShouldEqual, errBadCredentials)
+
+		_, err = ee.authenticateAPIKey("not a key")
+		So(err, ShouldEqual, errBadCredentials)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+// attributesField is the object of custom product attributes, strings are
+// mapped as keywords, numbers as doubles and booleans as booleans by dynamic templates
+const attributesField = "attributes"
+
+var attributeNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// attributeFilter matches products having the attribute value, lists match any item
+type attributeFilter struct {
+	name  string
+	value string
+}
+
+// validateAttributes checks that attributes are strings, numbers, booleans
+// or lists of values of one type, the same attribute must keep its type in all products
+func validateAttributes(attributes map[string]interface{}) error {
+	for name, value := range attributes {
+		if !attributeNameRegexp.MatchString(name) {
+			return fmt.Errorf("attribute name %q should be lowercase letters, digits and _", name)
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+
+		for _, v := range values {
+			switch v.(type) {
+			case string, float64, bool:
+			default:
+				return fmt.Errorf("attribute %q should be string, number, boolean or list of them", name)
+			}
+
+			if reflect.TypeOf(v) != reflect.TypeOf(values[0]) {
+				return fmt.Errorf("attribute %q list should have values of one type", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// attributeType returns the mapping type of the attribute value given by dynamic templates,
+// empty list has no type
+func attributeType(value interface{}) string {
+	if values, ok := value.([]interface{}); ok {
+		if len(values) == 0 {
+			return ""
+		}
+		value = values[0]
+	}
+
+	switch value.(type) {
+	case float64:
+		return "double"
+	case bool:
+		return "boolean"
+	default:
+		return "keyword"
+	}
+}
+
+// attributeTypeConflicts returns sorted attributes of products which type differs from the
+// mapped type or from the type of the same attribute in previous products. Elastic coerces
+// numbers to keywords and numeric strings to doubles, so it doesn't reject them itself.
+// Types of new attributes are added to types
+func attributeTypeConflicts(docs []Doc, types map[string]string) []string {
+	conflicts := []string{}
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		for name, value := range doc.Attributes {
+			t := attributeType(value)
+			if t == "" {
+				continue
+			}
+
+			if known, ok := types[name]; !ok {
+				types[name] = t
+			} else if known != t && !seen[name] {
+				seen[name] = true
+				conflicts = append(conflicts, fmt.Sprintf("%s should be %s", name, known))
+			}
+		}
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// attributeTypes returns mapping types of attributes indexed in the collection
+func (ee *elasticEngine) attributeTypes() (map[string]string, error) {
+	result, err := ee.client.GetMapping().Index(ee.index).Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get mapping")
+	}
+
+	types := map[string]string{}
+
+	// the alias resolves to one physical index
+	for _, index := range result {
+		body, err := json.Marshal(index)
+		if err != nil {
+			return nil, wrapper.Wrap(err, "error while marshal mapping")
+		}
+
+		mapping := struct {
+			Mappings struct {
+				Properties map[string]struct {
+					Properties map[string]struct {
+						Type string `json:"type"`
+					} `json:"properties"`
+				} `json:"properties"`
+			} `json:"mappings"`
+		}{}
+		if err := json.Unmarshal(body, &mapping); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal mapping")
+		}
+
+		for name, field := range mapping.Mappings.Properties[attributesField].Properties {
+			types[name] = field.Type
+		}
+	}
+
+	return types, nil
+}
+
+// attributeField returns the indexed field of the attribute
+func attributeField(name string) string {
+	return attributesField + "." + name
+}
+
+// addAttributeFilters narrows the query to products having all attribute values
+func addAttributeFilters(q elastic.Query, filters []attributeFilter) elastic.Query {
+	if len(filters) == 0 {
+		return q
+	}
+
+	query := elastic.NewBoolQuery().Must(q)
+	for _, filter := range filters {
+		query.Filter(elastic.NewTermQuery(attributeField(filter.name), filter.value))
+	}
+
+	return query
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	Convey("Check product attributes types", t, func() {
+		doc := Doc{}
+		So(json.Unmarshal([]byte(`{"attributes":{"color":"red","size":42,"waterproof":true,"sizes":[41,42]}}`), &doc), ShouldBeNil)
+		So(doc.validate(), ShouldBeNil)
+
+		So(json.Unmarshal([]byte(`{"attributes":{"sizes":[41,"XL"]}}`), &doc), ShouldBeNil)
+		So(doc.validate(), ShouldNotBeNil)
+
+		So(json.Unmarshal([]byte(`{"attributes":{"color":{"name":"red"}}}`), &doc), ShouldBeNil)
+		So(doc.validate(), ShouldNotBeNil)
+
+		So(json.Unmarshal([]byte(`{"attributes":{"Color.name":"red"}}`), &doc), ShouldBeNil)
+		So(doc.validate(), ShouldNotBeNil)
+	})
+}
+
+func TestAttributeTypeConflicts(t *testing.T) {
+	Convey("Check attributes keep their type in all products", t, func() {
+		docs := []Doc{}
+		So(json.Unmarshal([]byte(`[
+			{"attributes":{"color":"red","size":42}},
+			{"attributes":{"color":"blue","size":"XL","sizes":[]}},
+			{"attributes":{"waterproof":"true","size":"L"}}
+		]`), &docs), ShouldBeNil)
+
+		types := map[string]string{"waterproof": "boolean"}
+		So(attributeTypeConflicts(docs, types), ShouldResemble, []string{"size should be double", "waterproof should be boolean"})
+		So(types, ShouldResemble, map[string]string{"color": "keyword", "size": "double", "waterproof": "boolean"})
+
+		So(attributeTypeConflicts(docs[:1], map[string]string{}), ShouldBeEmpty)
+	})
+}
+
+func TestAttributeParams(t *testing.T) {
+	Convey("Check attribute filters and facets params", t, func() {
+		params := queryParams{}
+		r := httptest.NewRequest("GET", "/v1/products?attr=color:red&attr=size:42&facets=color,material", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(params.validate(), ShouldBeNil)
+		So(params.attributes, ShouldResemble, []attributeFilter{{name: "color", value: "red"}, {name: "size", value: "42"}})
+		So(params.facets, ShouldResemble, []string{"color", "material"})
+
+		params = queryParams{}
+		r = httptest.NewRequest("GET", "/v1/products?facets=color,bad%20name", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+	})
+}
@@ -4,42 +4,58 @@ import (
 	"crypto/rsa"
 	"errors"
 	"fmt"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	wrapper "github.com/pkg/errors"
 )
 
 const (
-	signedMethod = "RS256"
-	tokenType    = "search_access"
+	signedMethod     = "RS256"
+	tokenType        = "search_access"
+	refreshTokenType = "search_refresh"
 )
 
-// TokenInfo using to store info inside token
+// TokenInfo using to store info inside token,
+// empty tenant gives access to all collections
 type tokenInfo struct {
 	Source string
+	Tenant string
+	Scopes []string
+	// Generation is the token generation of the client, see Client
+	Generation int `json:",omitempty"`
 }
 
-// TokenClaims using to store std and custom info inside token
+// TokenClaims using to store std and custom info inside token,
+// family is the id of the first refresh token of the rotation chain
 type tokenClaims struct {
 	*jwt.StandardClaims
 	TokenType string
 	Info      tokenInfo
+	Family    string `json:",omitempty"`
 }
 
-// MakeToken create new encrypted token with claims
-func makeToken(signKey *rsa.PrivateKey, source string, expireAt int64) (string, error) {
-	t := jwt.New(jwt.GetSigningMethod(signedMethod))
-	fmt.Println(expireAt)
-	// set our claims
-	t.Claims = &tokenClaims{
-		&jwt.StandardClaims{
+// newTokenClaims makes claims of the token with the unique id, so the token can be revoked
+func newTokenClaims(kind string, info tokenInfo, expireAt int64) *tokenClaims {
+	return &tokenClaims{
+		StandardClaims: &jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: expireAt,
 		},
-		tokenType,
-		tokenInfo{Source: source},
+		TokenType: kind,
+		Info:      info,
 	}
+}
+
+// signToken signs claims, kid header is the id of the key, see keySet
+func signToken(signKey signingKey, claims *tokenClaims) (string, error) {
+	t := jwt.New(jwt.GetSigningMethod(signKey.alg))
+	t.Header[keyIDHeader] = signKey.id
+	t.Claims = claims
 
-	generatedToken, err := t.SignedString(signKey)
+	generatedToken, err := t.SignedString(signKey.key)
 	if err != nil {
 		return "", wrapper.Wrap(err, "error while get signed string")
 	}
@@ -47,16 +63,14 @@ func makeToken(signKey *rsa.PrivateKey, source string, expireAt int64) (string,
 	return generatedToken, nil
 }
 
-// VerifyToken should parse token info and return claims if token not invalid
-func verifyToken(token string, verifyKey *rsa.PublicKey) (tokenClaims, error) {
+// parseToken validates the token with the key returned by keyFunc
+func parseToken(token string, keyFunc jwt.Keyfunc) (tokenClaims, error) {
 	if len(token) == 0 {
 		return tokenClaims{}, errors.New("token is empty")
 	}
 
 	// validate the token
-	parsedToken, err := jwt.ParseWithClaims(token, &tokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
+	parsedToken, err := jwt.ParseWithClaims(token, &tokenClaims{}, keyFunc)
 
 	// branch out into the possible error from signing
 	switch err.(type) {
@@ -95,7 +109,3 @@ func verifyToken(token string, verifyKey *rsa.PublicKey) (tokenClaims, error) {
 func parsePrivateKey(key []byte) (*rsa.PrivateKey, error) {
 	return jwt.ParseRSAPrivateKeyFromPEM(key)
 }
-
-func parsePublicKey(key []byte) (*rsa.PublicKey, error) {
-	return jwt.ParseRSAPublicKeyFromPEM(key)
-}
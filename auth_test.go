@@ -18,7 +18,7 @@ const (
 
 func TestMakeToken(t *testing.T) {
 	Convey("Test make token", t, func() {
-		token, err := makeToken(getSignKey(t), "000000000", 3600)
+		token, err := signToken(rsaSigningKey(getSignKey(t)), newTokenClaims(tokenType, tokenInfo{Source: "000000000"}, 3600))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -31,7 +31,7 @@ func TestMakeToken(t *testing.T) {
 
 func TestTokenValidation(t *testing.T) {
 	Convey("Test token validation", t, func() {
-		stringToken, err := makeToken(getSignKey(t), "000000000", time.Now().Add(time.Minute).Unix())
+		stringToken, err := signToken(rsaSigningKey(getSignKey(t)), newTokenClaims(tokenType, tokenInfo{Source: "000000000"}, time.Now().Add(time.Minute).Unix()))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -40,7 +40,7 @@ func TestTokenValidation(t *testing.T) {
 			t.Fatal("token length is nil")
 		}
 
-		_, err = verifyToken(stringToken, getVerifyKey(t))
+		_, err = getVerifyKeys(t).verify(stringToken)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -49,7 +49,7 @@ func TestTokenValidation(t *testing.T) {
 
 func TestTokenExpiration(t *testing.T) {
 	Convey("Test token expiration", t, func() {
-		stringToken, err := makeToken(getSignKey(t), "000000000", time.Now().Add(time.Second).Unix())
+		stringToken, err := signToken(rsaSigningKey(getSignKey(t)), newTokenClaims(tokenType, tokenInfo{Source: "000000000"}, time.Now().Add(time.Second).Unix()))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -59,7 +59,7 @@ func TestTokenExpiration(t *testing.T) {
 			t.Fatal("token length is nil")
 		}
 
-		_, err = verifyToken(stringToken, getVerifyKey(t))
+		_, err = getVerifyKeys(t).verify(stringToken)
 		if err == nil {
 			t.Fatal("expect that token should be expired")
 		}
@@ -97,3 +97,10 @@ func getVerifyKey(t *testing.T) *rsa.PublicKey {
 
 	return verifyKey
 }
+
+// getVerifyKeys returns the key set of the test RS256 public key
+func getVerifyKeys(t *testing.T) keySet {
+	ks := keySet{}
+	ks.add(verifyKey{alg: signedMethod, key: getVerifyKey(t)})
+	return ks
+}
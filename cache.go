@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCacheMaxItems bounds the cache, expired items are dropped when it's full
+const ttlCacheMaxItems = 10000
+
+// ttlCache keeps values read from elastic on every request for a short time,
+// values are deleted on change, so only other instances see stale values until
+// they expire. Nil cache caches nothing
+type ttlCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]cacheItem
+}
+
+type cacheItem struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl, items: map[string]cacheItem{}}
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(item.expiresAt) {
+		delete(c.items, key)
+		return nil, false
+	}
+
+	return item.value, true
+}
+
+func (c *ttlCache) set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.items) >= ttlCacheMaxItems {
+		for k, item := range c.items {
+			if now.After(item.expiresAt) {
+				delete(c.items, k)
+			}
+		}
+	}
+
+	if len(c.items) >= ttlCacheMaxItems {
+		return
+	}
+
+	c.items[key] = cacheItem{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *ttlCache) delete(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, key)
+}
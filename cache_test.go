@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTTLCache(t *testing.T) {
+	Convey("Check values expire and are deleted", t, func() {
+		cache := newTTLCache(50 * time.Millisecond)
+		cache.set("a", 1)
+
+		value, ok := cache.get("a")
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, 1)
+
+		cache.delete("a")
+		_, ok = cache.get("a")
+		So(ok, ShouldBeFalse)
+
+		cache.set("b", 2)
+		<-time.After(100 * time.Millisecond)
+		_, ok = cache.get("b")
+		So(ok, ShouldBeFalse)
+
+		var empty *ttlCache
+		empty.set("c", 3)
+		_, ok = empty.get("c")
+		So(ok, ShouldBeFalse)
+	})
+}
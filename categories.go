@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	categoriesIndexName = "categories"
+	categoriesMaxCount  = 10000
+
+	// categorySeparator separates nodes of the category path, like shoes > running > trail
+	categorySeparator = " > "
+	// categoryFacet is the built-in hierarchical facet of product categories
+	categoryFacet = "category"
+
+	categoriesMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"collection":{
+				"type":"keyword"
+			},
+			"path":{
+				"type":"keyword"
+			},
+			"parent":{
+				"type":"keyword"
+			},
+			"name":{
+				"type":"keyword"
+			},
+			"created_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+var (
+	errCategoryExists      = errors.New("category already exists")
+	errCategoryHasChildren = errors.New("category has subcategories")
+	errNoParentCategory    = errors.New("parent category doesn't exist")
+	errCategoryInUse       = errors.New("category has products")
+)
+
+// Category is a node of the taxonomy of the collection, the path is unique in the collection
+type Category struct {
+	Collection string    `json:"collection,omitempty"`
+	Path       string    `json:"path"`
+	Parent     string    `json:"parent,omitempty"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CategoryNode is the category with its subcategories
+type CategoryNode struct {
+	Path     string         `json:"path"`
+	Name     string         `json:"name"`
+	Children []CategoryNode `json:"children,omitempty"`
+}
+
+// normalizeCategory trims names of the path nodes, so "shoes>running" and
+// "shoes > running" are the same category
+func normalizeCategory(path string) (string, error) {
+	nodes := strings.Split(path, strings.TrimSpace(categorySeparator))
+	for i, node := range nodes {
+		nodes[i] = strings.TrimSpace(node)
+		if nodes[i] == "" {
+			return "", fmt.Errorf("category %q has empty node", path)
+		}
+	}
+
+	return strings.Join(nodes, categorySeparator), nil
+}
+
+// categoryAncestors returns the normalized path with paths of all its ancestors from the root
+func categoryAncestors(path string) []string {
+	nodes := strings.Split(path, categorySeparator)
+	ancestors := make([]string, 0, len(nodes))
+	for i := range nodes {
+		ancestors = append(ancestors, strings.Join(nodes[:i+1], categorySeparator))
+	}
+
+	return ancestors
+}
+
+// newCategory builds the taxonomy node of the path
+func newCategory(path string) (Category, error) {
+	normalized, err := normalizeCategory(path)
+	if err != nil {
+		return Category{}, err
+	}
+
+	c := Category{Path: normalized, CreatedAt: time.Now().UTC()}
+	if i := strings.LastIndex(normalized, categorySeparator); i >= 0 {
+		c.Parent = normalized[:i]
+		c.Name = normalized[i+len(categorySeparator):]
+	} else {
+		c.Name = normalized
+	}
+
+	return c, nil
+}
+
+// setCategories normalizes the product category and indexes it with ancestors,
+// so filter by a category finds products of all its subcategories
+func (d *Doc) setCategories() {
+	d.Categories = nil
+	if d.Category == "" {
+		return
+	}
+
+	normalized, err := normalizeCategory(d.Category)
+	if err != nil {
+		return
+	}
+
+	d.Category = normalized
+	d.Categories = categoryAncestors(normalized)
+}
+
+// categoryTree builds the taxonomy tree ordered by names
+func categoryTree(categories []Category) []CategoryNode {
+	children := map[string][]Category{}
+	for _, c := range categories {
+		children[c.Parent] = append(children[c.Parent], c)
+	}
+
+	var build func(parent string) []CategoryNode
+	build = func(parent string) []CategoryNode {
+		nodes := []CategoryNode{}
+		for _, c := range children[parent] {
+			nodes = append(nodes, CategoryNode{Path: c.Path, Name: c.Name, Children: build(c.Path)})
+		}
+
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		return nodes
+	}
+
+	return build("")
+}
+
+// categoryFacetTree nests counts of categories and their ancestors into the facet tree
+func categoryFacetTree(counts map[string]int64) []FacetBucket {
+	children := map[string][]string{}
+	for path := range counts {
+		parent := ""
+		if i := strings.LastIndex(path, categorySeparator); i >= 0 {
+			parent = path[:i]
+		}
+		children[parent] = append(children[parent], path)
+	}
+
+	var build func(parent string) []FacetBucket
+	build = func(parent string) []FacetBucket {
+		buckets := []FacetBucket{}
+		for _, path := range children[parent] {
+			buckets = append(buckets, FacetBucket{Value: path, Count: counts[path], Children: build(path)})
+		}
+
+		sort.Slice(buckets, func(i, j int) bool {
+			if buckets[i].Count == buckets[j].Count {
+				return buckets[i].Value.(string) < buckets[j].Value.(string)
+			}
+
+			return buckets[i].Count > buckets[j].Count
+		})
+		return buckets
+	}
+
+	return build("")
+}
+
+func categoryAggregation() elastic.Aggregation {
+	return elastic.NewTermsAggregation().Field("categories").Size(categoriesMaxCount)
+}
+
+func categoryBuckets(aggregations elastic.Aggregations) ([]FacetBucket, error) {
+	terms, ok := aggregations.Terms(categoryFacet)
+	if !ok {
+		return nil, errors.New("no aggregation of category facet")
+	}
+
+	counts := map[string]int64{}
+	for _, bucket := range terms.Buckets {
+		if path, ok := bucket.Key.(string); ok {
+			counts[path] = bucket.DocCount
+		}
+	}
+
+	return categoryFacetTree(counts), nil
+}
+
+// addCategoryFilter narrows the query to products of the category and its subcategories
+func addCategoryFilter(q elastic.Query, category string) elastic.Query {
+	if category == "" {
+		return q
+	}
+
+	return elastic.NewBoolQuery().
+		Must(q).
+		Filter(elastic.NewTermQuery("categories", category))
+}
+
+// categoryID returns the id of the category document, categories of the default
+// collection have ids of their paths as before collections had taxonomies
+func categoryID(collection, path string) string {
+	if collection == defaultCollectionName {
+		return path
+	}
+
+	return collection + "/" + path
+}
+
+// categoriesQuery matches categories of the collection, categories created before
+// collections had taxonomies belong to the default one
+func (ee *elasticEngine) categoriesQuery() *elastic.BoolQuery {
+	query := elastic.NewBoolQuery().
+		Should(elastic.NewTermQuery("collection", ee.collection))
+	if ee.collection == defaultCollectionName {
+		query.Should(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("collection")))
+	}
+
+	return query
+}
+
+// putCategory adds the node to the taxonomy of the collection, the parent should exist
+func (ee *elasticEngine) putCategory(c Category) (Category, error) {
+	c.Collection = ee.collection
+	if c.Parent != "" {
+		if _, err := ee.getCategory(c.Parent); err == errNotFound {
+			return Category{}, errNoParentCategory
+		} else if err != nil {
+			return Category{}, err
+		}
+	}
+
+	_, err := ee.client.Index().
+		Index(categoriesIndexName).
+		Id(categoryID(ee.collection, c.Path)).
+		OpType("create").
+		BodyJson(c).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsConflict(err) {
+		return Category{}, errCategoryExists
+	}
+	if err != nil {
+		return Category{}, wrapper.Wrap(err, "error while put category")
+	}
+
+	return c, nil
+}
+
+func (ee *elasticEngine) getCategory(path string) (Category, error) {
+	result, err := ee.client.Get().
+		Index(categoriesIndexName).
+		Id(categoryID(ee.collection, path)).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return Category{}, errNotFound
+	}
+	if err != nil {
+		return Category{}, wrapper.Wrap(err, "error while get category")
+	}
+
+	c := Category{}
+	if err := json.Unmarshal(result.Source, &c); err != nil {
+		return Category{}, wrapper.Wrap(err, "error while unmarshal category")
+	}
+
+	// the path of the default collection may look like the id of another collection category
+	if c.Collection != "" && c.Collection != ee.collection {
+		return Category{}, errNotFound
+	}
+
+	c.Collection = ee.collection
+	return c, nil
+}
+
+// categories returns the taxonomy of the collection
+func (ee *elasticEngine) categories() ([]Category, error) {
+	result, err := ee.client.Search().
+		Index(categoriesIndexName).
+		Query(ee.categoriesQuery()).
+		Size(categoriesMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get categories")
+	}
+
+	categories := []Category{}
+	for _, hit := range result.Hits.Hits {
+		c := Category{}
+		if err := json.Unmarshal(hit.Source, &c); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal category")
+		}
+
+		c.Collection = ee.collection
+		categories = append(categories, c)
+	}
+
+	return categories, nil
+}
+
+// missingCategories returns paths which are not in the taxonomy of the collection
+func (ee *elasticEngine) missingCategories(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	mget := ee.client.Mget()
+	for _, path := range paths {
+		mget.Add(elastic.NewMultiGetItem().Index(categoriesIndexName).Id(categoryID(ee.collection, path)))
+	}
+
+	result, err := mget.Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get categories")
+	}
+
+	missing := []string{}
+	for i, doc := range result.Docs {
+		if !doc.Found {
+			missing = append(missing, paths[i])
+		}
+	}
+
+	return missing, nil
+}
+
+// deleteCategory removes the leaf node of the taxonomy which has no products
+func (ee *elasticEngine) deleteCategory(path string) error {
+	if _, err := ee.getCategory(path); err != nil {
+		return err
+	}
+
+	children, err := ee.client.Count(categoriesIndexName).
+		Query(elastic.NewBoolQuery().
+			Filter(elastic.NewTermQuery("parent", path)).
+			Filter(ee.categoriesQuery())).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while count subcategories")
+	}
+
+	if children > 0 {
+		return errCategoryHasChildren
+	}
+
+	products, err := ee.client.Count(ee.index).
+		Query(elastic.NewTermQuery("categories", path)).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while count products of category")
+	}
+
+	if products > 0 {
+		return errCategoryInUse
+	}
+
+	_, err = ee.client.Delete().
+		Index(categoriesIndexName).
+		Id(categoryID(ee.collection, path)).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete category")
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeCategory(t *testing.T) {
+	Convey("Check category paths are normalized", t, func() {
+		path, err := normalizeCategory(" shoes>running >  trail ")
+		So(err, ShouldBeNil)
+		So(path, ShouldEqual, "shoes > running > trail")
+
+		So(categoryAncestors(path), ShouldResemble, []string{"shoes", "shoes > running", "shoes > running > trail"})
+
+		_, err = normalizeCategory("shoes > > trail")
+		So(err, ShouldNotBeNil)
+
+		c, err := newCategory("shoes>running")
+		So(err, ShouldBeNil)
+		So(c.Parent, ShouldEqual, "shoes")
+		So(c.Name, ShouldEqual, "running")
+
+		doc := Doc{Category: "shoes>running"}
+		doc.setCategories()
+		So(doc.Category, ShouldEqual, "shoes > running")
+		So(doc.Categories, ShouldResemble, []string{"shoes", "shoes > running"})
+
+		params := queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?category=shoes%3Erunning", nil)), ShouldBeNil)
+		So(params.category, ShouldEqual, "shoes > running")
+	})
+}
+
+func TestCategoryID(t *testing.T) {
+	Convey("Check categories of collections have own ids", t, func() {
+		So(categoryID(defaultCollectionName, "shoes > running"), ShouldEqual, "shoes > running")
+		So(categoryID("outlet", "shoes > running"), ShouldEqual, "outlet/shoes > running")
+	})
+}
+
+func TestCategoryTree(t *testing.T) {
+	Convey("Check taxonomy and facet trees", t, func() {
+		categories := []Category{}
+		for _, path := range []string{"shoes", "shoes > trail", "shoes > running", "bags"} {
+			c, err := newCategory(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			categories = append(categories, c)
+		}
+
+		tree := categoryTree(categories)
+		So(len(tree), ShouldEqual, 2)
+		So(tree[0].Name, ShouldEqual, "bags")
+		So(tree[1].Name, ShouldEqual, "shoes")
+		So(tree[1].Children[0].Path, ShouldEqual, "shoes > running")
+		So(tree[1].Children[1].Path, ShouldEqual, "shoes > trail")
+
+		facet := categoryFacetTree(map[string]int64{
+			"shoes":                   5,
+			"shoes > running":         3,
+			"shoes > running > trail": 1,
+			"shoes > hiking":          2,
+			"bags":                    1,
+		})
+		So(len(facet), ShouldEqual, 2)
+		So(facet[0].Value, ShouldEqual, "shoes")
+		So(facet[0].Count, ShouldEqual, 5)
+		So(facet[0].Children[0].Value, ShouldEqual, "shoes > running")
+		So(facet[0].Children[0].Children[0].Count, ShouldEqual, 1)
+		So(facet[0].Children[1].Value, ShouldEqual, "shoes > hiking")
+	})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	clientsIndexName = "clients"
+	clientsMaxCount  = 1000
+
+	clientSecretBytes = 32
+
+	clientsMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"name":{
+				"type":"keyword"
+			},
+			"secret_hash":{
+				"type":"keyword",
+				"index":false
+			},
+			"enabled":{
+				"type":"boolean"
+			},
+			"tenant":{
+				"type":"keyword"
+			},
+			"scopes":{
+				"type":"keyword"
+			},
+			"metadata":{
+				"type":"object",
+				"enabled":false
+			},
+			"created_at":{
+				"type":"date"
+			},
+			"updated_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+var errBadCredentials = errors.New("bad credentials")
+
+// Client is a registered API consumer, the secret is shown only once on create
+// and rotate, the registry keeps only its hash. Scopes limit routes the client
+// tokens can access. Generation is increased when the secret is rotated or the client
+// is disabled, tokens of the previous generation are revoked
+type Client struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	SecretHash string            `json:"secret_hash,omitempty"`
+	Enabled    bool              `json:"enabled"`
+	Generation int               `json:"generation"`
+	Tenant     string            `json:"tenant,omitempty"`
+	Scopes     []string          `json:"scopes,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// ClientUpdate changes the client, omitted tenant and enabled keep stored values,
+// empty tenant unbinds the client from the tenant
+type ClientUpdate struct {
+	Name     string            `json:"name"`
+	Tenant   *string           `json:"tenant,omitempty"`
+	Scopes   []string          `json:"scopes,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Enabled  *bool             `json:"enabled,omitempty"`
+}
+
+// ClientCredentials is the response of client create and secret rotate
+type ClientCredentials struct {
+	Client
+	Secret string `json:"secret"`
+}
+
+func (c *Client) validate() error {
+	if c.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	return validateScopes(c.Scopes)
+}
+
+func (cu *ClientUpdate) validate() error {
+	return (&Client{Name: cu.Name, Scopes: cu.Scopes}).validate()
+}
+
+// newClientSecret generates the random secret, secrets are not chosen by people,
+// so the plain SHA-256 hash is enough to store them
+func newClientSecret() (string, string, error) {
+	secret := make([]byte, clientSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", wrapper.Wrap(err, "error while generate secret")
+	}
+
+	encoded := hex.EncodeToString(secret)
+	return encoded, hashSecret(encoded), nil
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// checkSecret compares the secret with the stored hash in constant time
+func (c *Client) checkSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(c.SecretHash)) == 1
+}
+
+// parseCredentials splits credentials in format id:secret
+func parseCredentials(creds string) (string, string, error) {
+	parts := strings.SplitN(creds, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("can't parse credentials, expect id:secret")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// createClient registers the enabled client with the new secret
+func (ee *elasticEngine) createClient(c Client) (ClientCredentials, error) {
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return ClientCredentials{}, err
+	}
+
+	c.ID = uuid.New().String()
+	c.SecretHash = hash
+	c.Enabled = true
+	if len(c.Scopes) == 0 {
+		c.Scopes = defaultScopes
+	}
+	c.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = c.CreatedAt
+
+	if err := ee.putClient(c); err != nil {
+		return ClientCredentials{}, err
+	}
+
+	return ClientCredentials{Client: c, Secret: secret}, nil
+}
+
+// rotateClientSecret replaces the secret, the old one stops working immediately
+func (ee *elasticEngine) rotateClientSecret(id string) (ClientCredentials, error) {
+	c, err := ee.getClient(id)
+	if err != nil {
+		return ClientCredentials{}, err
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return ClientCredentials{}, err
+	}
+
+	c.SecretHash = hash
+	c.Generation++
+	c.UpdatedAt = time.Now().UTC()
+	if err := ee.putClient(c); err != nil {
+		return ClientCredentials{}, err
+	}
+
+	return ClientCredentials{Client: c, Secret: secret}, nil
+}
+
+// apply changes the client by the update
+func (c *Client) apply(update ClientUpdate) {
+	c.Name = update.Name
+	if update.Tenant != nil {
+		c.Tenant = *update.Tenant
+	}
+	c.Scopes = update.Scopes
+	if len(c.Scopes) == 0 {
+		c.Scopes = defaultScopes
+	}
+	c.Metadata = update.Metadata
+	if update.Enabled != nil {
+		c.Enabled = *update.Enabled
+		if !c.Enabled {
+			c.Generation++
+		}
+	}
+	c.UpdatedAt = time.Now().UTC()
+}
+
+// updateClient changes name, tenant, scopes, metadata and enabled flag of the client,
+// disabling starts the new token generation
+func (ee *elasticEngine) updateClient(id string, update ClientUpdate) (Client, error) {
+	c, err := ee.getClient(id)
+	if err != nil {
+		return Client{}, err
+	}
+
+	c.apply(update)
+	if err := ee.putClient(c); err != nil {
+		return Client{}, err
+	}
+
+	return c, nil
+}
+
+// authenticateClient returns the enabled client with the secret
+func (ee *elasticEngine) authenticateClient(id, secret string) (Client, error) {
+	c, err := ee.getClient(id)
+	if err == errNotFound {
+		return Client{}, errBadCredentials
+	}
+	if err != nil {
+		return Client{}, err
+	}
+
+	if !c.Enabled || !c.checkSecret(secret) {
+		return Client{}, errBadCredentials
+	}
+
+	return c, nil
+}
+
+func (ee *elasticEngine) putClient(c Client) error {
+	_, err := ee.client.Index().
+		Index(clientsIndexName).
+		Id(c.ID).
+		BodyJson(c).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while put client")
+	}
+
+	return nil
+}
+
+func (ee *elasticEngine) getClient(id string) (Client, error) {
+	result, err := ee.client.Get().
+		Index(clientsIndexName).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return Client{}, errNotFound
+	}
+	if err != nil {
+		return Client{}, wrapper.Wrap(err, "error while get client")
+	}
+
+	c := Client{}
+	if err := json.Unmarshal(result.Source, &c); err != nil {
+		return Client{}, wrapper.Wrap(err, "error while unmarshal client")
+	}
+
+	return c, nil
+}
+
+func (ee *elasticEngine) clients() ([]Client, error) {
+	result, err := ee.client.Search().
+		Index(clientsIndexName).
+		Query(elastic.NewMatchAllQuery()).
+		SortBy(elastic.NewFieldSort("name")).
+		Size(clientsMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get clients")
+	}
+
+	clients := []Client{}
+	for _, hit := range result.Hits.Hits {
+		c := Client{}
+		if err := json.Unmarshal(hit.Source, &c); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal client")
+		}
+
+		clients = append(clients, c)
+	}
+
+	return clients, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientSecret(t *testing.T) {
+	Convey("Check client secrets are stored hashed", t, func() {
+		secret, hash, err := newClientSecret()
+		So(err, ShouldBeNil)
+		So(len(secret), ShouldEqual, clientSecretBytes*2)
+		So(hash, ShouldNotEqual, secret)
+
+		c := Client{Name: "partner", SecretHash: hash}
+		So(c.validate(), ShouldBeNil)
+		So(c.checkSecret(secret), ShouldBeTrue)
+		So(c.checkSecret(secret+"x"), ShouldBeFalse)
+		So(c.checkSecret(hash), ShouldBeFalse)
+
+		other, _, err := newClientSecret()
+		So(err, ShouldBeNil)
+		So(other, ShouldNotEqual, secret)
+
+		So((&Client{}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestParseCredentials(t *testing.T) {
+	Convey("Check credentials are split into id and secret", t, func() {
+		id, secret, err := parseCredentials("partner:s3cr:et")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "partner")
+		So(secret, ShouldEqual, "s3cr:et")
+
+		_, _, err = parseCredentials("partner")
+		So(err, ShouldNotBeNil)
+
+		_, _, err = parseCredentials(":secret")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRevokeClientTokens(t *testing.T) {
+	Convey("Check tokens of the previous client generation are revoked", t, func() {
+		h := apiHandler{tokenExpireAt: 3600, refreshExpireAt: 7200, revocations: newMemoryRevocations()}
+		So(h.readKeys(signedMethod, TestSignKeysPath, TestVerifyKeysPath), ShouldBeNil)
+
+		client := Client{ID: "partner", Enabled: true}
+		old, err := h.issueToken(client, nil)
+		So(err, ShouldBeNil)
+		_, err = h.checkToken(old, tokenType)
+		So(err, ShouldBeNil)
+
+		client.Generation++
+		So(h.revokeClientTokens(client), ShouldBeNil)
+		_, err = h.checkToken(old, tokenType)
+		So(err, ShouldNotBeNil)
+
+		current, err := h.issueToken(client, nil)
+		So(err, ShouldBeNil)
+		_, err = h.checkToken(current, tokenType)
+		So(err, ShouldBeNil)
+
+		So((&ClientUpdate{Name: "partner"}).validate(), ShouldBeNil)
+		So((&ClientUpdate{}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestClientApply(t *testing.T) {
+	Convey("Check update of the global admin without tenant keeps the client tenant", t, func() {
+		client := Client{ID: "partner", Tenant: "acme", Enabled: true}
+		client.apply(ClientUpdate{Name: "partner-shop"})
+		So(client.Name, ShouldEqual, "partner-shop")
+		So(client.Tenant, ShouldEqual, "acme")
+		So(client.Enabled, ShouldBeTrue)
+		So(client.Scopes, ShouldResemble, defaultScopes)
+
+		tenant := "shoes-shop"
+		client.apply(ClientUpdate{Name: "partner-shop", Tenant: &tenant})
+		So(client.Tenant, ShouldEqual, "shoes-shop")
+
+		unbound := ""
+		client.apply(ClientUpdate{Name: "partner-shop", Tenant: &unbound})
+		So(client.Tenant, ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	collectionsIndexName  = "collections"
+	collectionsMaxCount   = 1000
+	collectionIndexPrefix = "products_"
+
+	// defaultCollectionName is the collection of the legacy /v1/products
+	// routes for tokens without tenant, it's stored in elasticIndexName
+	defaultCollectionName = "default"
+
+	collectionsMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"name":{
+				"type":"keyword"
+			},
+			"index":{
+				"type":"keyword"
+			},
+			"created_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+var (
+	errCollectionExists = errors.New("collection already exists")
+	// errCollectionMismatch is returned for objects of another collection
+	errCollectionMismatch = errors.New("object belongs to another collection")
+
+	collectionNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	// versionSuffixRegexp matches names clashing with physical index versions
+	versionSuffixRegexp = regexp.MustCompile(indexVersionSeparator + `[0-9]+$`)
+)
+
+// Collection is a separate catalog of a tenant stored in its own index,
+// index is the alias of the current index version
+type Collection struct {
+	Name      string    `json:"name"`
+	Index     string    `json:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *Collection) validate() error {
+	if !collectionNameRegexp.MatchString(c.Name) {
+		return errors.New("collection name should be lowercase letters, digits, - and _")
+	}
+
+	if c.Name == defaultCollectionName || versionSuffixRegexp.MatchString(c.Name) {
+		return errors.New("collection name is reserved")
+	}
+
+	return nil
+}
+
+// forCollection returns the engine working with documents of the collection
+func (ee elasticEngine) forCollection(c Collection) elasticEngine {
+	ee.collection = c.Name
+	ee.index = c.Index
+	return ee
+}
+
+func (ee *elasticEngine) createCollection(name string) (Collection, error) {
+	c := Collection{
+		Name:      name,
+		Index:     collectionIndexPrefix + name,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err := ee.client.Index().
+		Index(collectionsIndexName).
+		Id(c.Name).
+		OpType("create").
+		BodyJson(c).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsConflict(err) {
+		return Collection{}, errCollectionExists
+	}
+	if err != nil {
+		return Collection{}, wrapper.Wrap(err, "error while put collection")
+	}
+
+	collection := ee.forCollection(c)
+	if err := collection.createIndex(); err != nil {
+		return Collection{}, wrapper.Wrap(err, "error while create collection index")
+	}
+
+	return c, nil
+}
+
+func (ee *elasticEngine) getCollection(name string) (Collection, error) {
+	result, err := ee.client.Get().
+		Index(collectionsIndexName).
+		Id(name).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return Collection{}, errNotFound
+	}
+	if err != nil {
+		return Collection{}, wrapper.Wrap(err, "error while get collection")
+	}
+
+	c := Collection{}
+	if err := json.Unmarshal(result.Source, &c); err != nil {
+		return Collection{}, wrapper.Wrap(err, "error while unmarshal collection")
+	}
+
+	return c, nil
+}
+
+func (ee *elasticEngine) collections() ([]Collection, error) {
+	result, err := ee.client.Search().
+		Index(collectionsIndexName).
+		Query(elastic.NewMatchAllQuery()).
+		SortBy(elastic.NewFieldSort("name")).
+		Size(collectionsMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get collections")
+	}
+
+	collections := []Collection{}
+	for _, hit := range result.Hits.Hits {
+		c := Collection{}
+		if err := json.Unmarshal(hit.Source, &c); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal collection")
+		}
+
+		collections = append(collections, c)
+	}
+
+	return collections, nil
+}
+
+// deleteCollection removes the collection with all its documents
+func (ee *elasticEngine) deleteCollection(name string) error {
+	c, err := ee.getCollection(name)
+	if err != nil {
+		return err
+	}
+
+	if err := ee.deleteIndex(c.Index); err != nil && !elastic.IsNotFound(wrapper.Cause(err)) {
+		return wrapper.Wrap(err, "error while delete collection index")
+	}
+
+	if err := ee.deleteVersion(c.Index); err != nil {
+		return err
+	}
+
+	_, err = ee.client.Delete().
+		Index(collectionsIndexName).
+		Id(name).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete collection")
+	}
+
+	return nil
+}
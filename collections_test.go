@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollectionValidate(t *testing.T) {
+	Convey("Check collection name validation", t, func() {
+		So((&Collection{Name: "shop-1"}).validate(), ShouldBeNil)
+		So((&Collection{Name: "Shop"}).validate(), ShouldNotBeNil)
+		So((&Collection{Name: "-shop"}).validate(), ShouldNotBeNil)
+		So((&Collection{Name: defaultCollectionName}).validate(), ShouldNotBeNil)
+		So((&Collection{Name: "shop_v2"}).validate(), ShouldNotBeNil)
+		So((&Collection{Name: "shop_vip"}).validate(), ShouldBeNil)
+	})
+}
+
+func TestCollectionAccess(t *testing.T) {
+	Convey("Check tenant can't access other collections", t, func() {
+		h := apiHandler{elastic: elasticEngine{collection: defaultCollectionName, index: elasticIndexName}}
+
+		request := func(tenant string, vars map[string]string) *http.Request {
+			r := httptest.NewRequest("GET", "/v1/products", nil)
+			ctx := context.WithValue(r.Context(), claimsContextKey, tokenClaims{Info: tokenInfo{Tenant: tenant}})
+			return mux.SetURLVars(r.WithContext(ctx), vars)
+		}
+
+		rr := httptest.NewRecorder()
+		_, ok := h.engine(rr, request("shop", map[string]string{"name": "other"}))
+		So(ok, ShouldBeFalse)
+		So(rr.Code, ShouldEqual, http.StatusForbidden)
+
+		rr = httptest.NewRecorder()
+		_, ok = h.engine(rr, request("shop", map[string]string{"name": defaultCollectionName}))
+		So(ok, ShouldBeFalse)
+		So(rr.Code, ShouldEqual, http.StatusForbidden)
+
+		rr = httptest.NewRecorder()
+		engine, ok := h.engine(rr, request("", nil))
+		So(ok, ShouldBeTrue)
+		So(engine.index, ShouldEqual, elasticIndexName)
+
+		So(h.elastic.forCollection(Collection{Name: "shop", Index: "products_shop"}).index, ShouldEqual, "products_shop")
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runCommand runs the maintenance command instead of the server:
+//
+//	migrate [collection]  - apply pending migrations, all collections without name
+//	reindex [collection]  - copy the collection to the new index version and swap the alias
+//	rollback [collection] - point the collection alias back to the previous index version
+func runCommand(elasticAddr string, migrationsPath string, args []string) error {
+	engine, err := newElasticEngine(elasticAddr, migrationsPath)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	} else if args[0] == "migrate" {
+		return engine.migrateCollections()
+	}
+
+	engine, err = commandEngine(engine, name)
+	if err != nil {
+		return err
+	}
+
+	var result ReindexResult
+	switch args[0] {
+	case "migrate":
+		unlock, err := engine.lockMigrations()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		return engine.migrate()
+	case "reindex":
+		result, err = engine.reindex(engine.schema().Body)
+	case "rollback":
+		result, err = engine.rollback()
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("alias %s moved from %s to %s, %d documents", result.Alias, result.From, result.To, result.Count)
+	return nil
+}
+
+// commandEngine returns the engine of the collection, empty name means the default collection
+func commandEngine(engine elasticEngine, name string) (elasticEngine, error) {
+	if name == "" || name == defaultCollectionName {
+		return engine, nil
+	}
+
+	c, err := engine.getCollection(name)
+	if err != nil {
+		return elasticEngine{}, fmt.Errorf("collection %q: %v", name, err)
+	}
+
+	return engine.forCollection(c), nil
+}
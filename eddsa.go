@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/ed25519"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodEdDSA is EdDSA of RFC 8037 with Ed25519 keys, jwt-go v3 doesn't have it
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(signedMethodEdDSA, func() jwt.SigningMethod {
+		return &signingMethodEdDSA{}
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return signedMethodEdDSA
+}
+
+// Verify checks the signature with ed25519.PublicKey
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+// Sign signs with ed25519.PrivateKey
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
@@ -14,18 +14,49 @@ import (
 
 const (
 	elasticIndexName = "documents"
+	scoreSortField   = "_score"
+	defaultSortField = "name"
 )
 
+var errNotFound = errors.New("not found")
+
+// searchResult is a page of found documents with requested facets
+type searchResult struct {
+	hits   []Hit
+	total  int64
+	facets map[string][]FacetBucket
+}
+
+const (
+	docCreated = "product.created"
+	docUpdated = "product.updated"
+	docDeleted = "product.deleted"
+)
+
+// indexHook is called with the collection, catalog change event and changed documents,
+// deleted documents have only id
+type indexHook func(collection, event string, docs []Doc)
+
+// elasticEngine works with documents of one collection, engines of other
+// collections share the client and hooks
 type elasticEngine struct {
-	client *elastic.Client
+	client       *elastic.Client
+	hooks        []indexHook
+	migrations   []migration
+	rulesCache   *ttlCache
+	apiKeysCache *ttlCache
+
+	collection string
+	index      string
 }
 
-func newElasticEngine(elasticAddr string) (elasticEngine, error) {
-	var (
-		elasticClient *elastic.Client
-		err           error
-	)
+func newElasticEngine(elasticAddr string, migrationsPath string) (elasticEngine, error) {
+	migrations, err := readMigrations(migrationsPath)
+	if err != nil {
+		return elasticEngine{}, err
+	}
 
+	var elasticClient *elastic.Client
 	for i := 0; i < 10; i++ {
 		elasticClient, err = elastic.NewClient(
 			elastic.SetURL(elasticAddr),
@@ -44,124 +75,409 @@ func newElasticEngine(elasticAddr string) (elasticEngine, error) {
 	}
 
 	client := elasticEngine{
-		client: elasticClient,
+		client:       elasticClient,
+		migrations:   migrations,
+		rulesCache:   newTTLCache(rulesCacheTTL),
+		apiKeysCache: newTTLCache(apiKeysCacheTTL),
+		collection:   defaultCollectionName,
+		index:        elasticIndexName,
 	}
 
-	if err := client.createIndex(); err != nil {
-		return elasticEngine{}, wrapper.Wrap(err, "error while create index")
+	if err := client.ensureIndex(schemaVersionsIndexName, schemaVersionsMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create schema versions index")
+	}
+
+	if err := client.ensureIndex(clientsIndexName, clientsMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create clients index")
+	}
+
+	if err := client.ensureIndex(apiKeysIndexName, apiKeysMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create api keys index")
+	}
+
+	if err := client.ensureIndex(collectionsIndexName, collectionsMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create collections index")
+	}
+
+	if err := client.ensureIndex(categoriesIndexName, categoriesMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create categories index")
+	}
+
+	if err := client.ensureIndex(rulesIndexName, rulesMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create rules index")
+	}
+
+	if err := client.createSavedSearchesIndex(); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create saved searches index")
+	}
+
+	if err := client.ensureIndex(webhooksIndexName, webhooksMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create webhooks index")
+	}
+
+	if err := client.ensureIndex(deadLettersIndexName, deadLettersMapping); err != nil {
+		return elasticEngine{}, wrapper.Wrap(err, "error while create dead letters index")
 	}
 
 	return client, nil
 }
 
-func (ee *elasticEngine) put(shoes []Doc) error {
+// onIndexed registers the hook called after each successful catalog change
+func (ee *elasticEngine) onIndexed(hook indexHook) {
+	ee.hooks = append(ee.hooks, hook)
+}
+
+// PutResult lists ids of created products and products which were not stored
+type PutResult struct {
+	Created []string    `json:"created"`
+	Failed  []PutFailed `json:"failed"`
+}
+
+// PutFailed is the product rejected by elastic, index is its position in the request
+type PutFailed struct {
+	Index int    `json:"index"`
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// put indexes products in one bulk request, products rejected by elastic like ones with
+// the attribute of another type are reported and only stored products are passed to hooks
+func (ee *elasticEngine) put(shoes []Doc) (PutResult, error) {
 	bulk := ee.client.
 		Bulk().
-		Index(elasticIndexName)
+		Index(ee.index)
 
+	indexed := make([]Doc, 0, len(shoes))
 	for _, shoe := range shoes {
 		shoe.ID = uuid.New().String()
 		shoe.CreatedAt = time.Now().UTC()
+		shoe.setCategories()
+		shoe.setAvailability()
+		shoe.setLocations()
+		shoe.setEffectivePrice()
 		bulk.Add(elastic.NewBulkIndexRequest().Id(shoe.ID).Doc(shoe))
+		indexed = append(indexed, shoe)
+	}
+
+	resp, err := bulk.Do(context.Background())
+	if err != nil {
+		return PutResult{}, wrapper.Wrap(err, "error while insert value to elastic")
+	}
+
+	failed := map[string]string{}
+	for _, item := range resp.Failed() {
+		reason := "failed"
+		if item.Error != nil {
+			reason = item.Error.Reason
+		}
+		failed[item.Id] = reason
+	}
+
+	result := PutResult{Created: []string{}, Failed: []PutFailed{}}
+	created := make([]Doc, 0, len(indexed))
+	for i, shoe := range indexed {
+		if reason, ok := failed[shoe.ID]; ok {
+			result.Failed = append(result.Failed, PutFailed{Index: i, ID: shoe.ID, Error: reason})
+			continue
+		}
+
+		result.Created = append(result.Created, shoe.ID)
+		created = append(created, shoe)
+	}
+
+	if len(created) > 0 {
+		ee.runHooks(docCreated, created)
+	}
+
+	return result, nil
+}
+
+// update replaces fields of the document, id and created_at are kept.
+// Locations are rebuilt with the stored location and stores when they are not given
+func (ee *elasticEngine) update(id string, doc Doc) (Doc, error) {
+	doc.setCategories()
+	doc.setAvailability()
+	doc.setLocations()
+	doc.setEffectivePrice()
+	if doc.Location == nil || len(doc.Stores) == 0 {
+		stored, err := ee.docsByIDs([]string{id}, queryParams{})
+		if err != nil {
+			return Doc{}, err
+		}
+		if _, ok := stored[id]; !ok {
+			return Doc{}, errNotFound
+		}
+
+		location, stores := doc.Location, doc.Stores
+		if location == nil {
+			location = stored[id].Location
+		}
+		if len(stores) == 0 {
+			stores = stored[id].Stores
+		}
+		doc.Locations = productLocations(location, stores)
 	}
 
-	if _, err := bulk.Do(context.Background()); err != nil {
-		return wrapper.Wrap(err, "error while insert value to elastic")
+	fields, err := updateFields(doc)
+	if err != nil {
+		return Doc{}, err
 	}
 
+	return ee.partialUpdate(id, fields)
+}
+
+// updateFields returns fields of the document which are changed by the update, popularity
+// is kept like id and created_at, it's changed only by PUT /products/{id}/popularity
+func updateFields(doc Doc) (map[string]interface{}, error) {
+	fields, err := docFields(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(fields, "id")
+	delete(fields, "created_at")
+	delete(fields, "popularity")
+
+	return fields, nil
+}
+
+func (ee *elasticEngine) delete(id string) error {
+	_, err := ee.client.Delete().
+		Index(ee.index).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete doc")
+	}
+
+	ee.runHooks(docDeleted, []Doc{Doc{ID: id}})
 	return nil
 }
 
-func (ee *elasticEngine) get(params queryParams) ([]Doc, error) {
-	var esQuery elastic.Query
+func (ee *elasticEngine) runHooks(event string, docs []Doc) {
+	for _, hook := range ee.hooks {
+		hook(ee.collection, event, docs)
+	}
+}
+
+// docFields converts the document to the map of its JSON fields
+func docFields(doc Doc) (map[string]interface{}, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while marshal doc")
+	}
 
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, wrapper.Wrap(err, "error while unmarshal doc fields")
+	}
+
+	return fields, nil
+}
+
+func (ee *elasticEngine) get(params queryParams) (searchResult, error) {
 	log.Infof("start query with params: %+v", params)
 
-	switch params.q {
-	case "":
-		esQuery = elastic.NewMatchAllQuery()
-	default:
-		esQuery = elastic.NewMultiMatchQuery(params.q, "name", "brand", "key_words").
-			Fuzziness("2").
-			MinimumShouldMatch("2")
+	merch, err := ee.merchandising(params.q)
+	if err != nil {
+		return searchResult{}, wrapper.Wrap(err, "error while get merchandising rules")
 	}
 
-	esQuery = addFilter(esQuery, params.filterField, params.filterValue)
+	pinned, err := ee.docsByIDs(pinIDs(merch.pins), params)
+	if err != nil {
+		return searchResult{}, err
+	}
+
+	merch = merch.found(pinned)
+	if params.sortBy == "" {
+		params.sortBy = params.defaultSort(merch)
+	}
+
+	esQuery := params.ranking.query(merch.query(searchQuery(params)))
+	from, size, pins := merch.page(params.offset, params.limit)
+
+	search := ee.client.Search().
+		Index(ee.index).
+		Query(esQuery).
+		SortBy(sorter(params)).
+		From(from).Size(size)
+	if params.geo != nil && params.sortBy != distanceSortField {
+		// the distance is the last sort value of hits
+		search.SortBy(distanceSort(params))
+	}
+	if params.hasFacet(priceFacet) && len(params.priceRanges) == 0 {
+		if params.priceInterval, err = ee.priceInterval(searchQuery(params), params); err != nil {
+			return searchResult{}, err
+		}
+	}
+	for _, facet := range params.facets {
+		search.Aggregation(facet, facetAggregation(facet, params))
+	}
+
+	result, err := search.Do(context.Background())
+	if err != nil {
+		return searchResult{}, wrapper.Wrap(err, "error while do shoes query")
+	}
+
+	facets := map[string][]FacetBucket{}
+	for _, facet := range params.facets {
+		buckets, err := facetBuckets(result.Aggregations, facet, params)
+		if err != nil {
+			return searchResult{}, err
+		}
+		facets[facet] = buckets
+	}
+
+	organic, err := docsFromHits(result)
+	if err != nil {
+		return searchResult{}, err
+	}
+
+	variants, err := matchingVariants(result)
+	if err != nil {
+		return searchResult{}, err
+	}
+
+	distances := map[string]float64{}
+	if params.geo != nil {
+		for _, hit := range result.Hits.Hits {
+			if distance, ok := hitDistance(hit); ok {
+				distances[hit.Id] = distance
+			}
+		}
+	}
+
+	hits := merch.splice(params.offset, organic, pins, pinned)
+	for i := range hits {
+		hits[i].MatchingVariants = variants[hits[i].ID]
+		if distance, ok := distances[hits[i].ID]; ok {
+			hits[i].DistanceKm = &distance
+		}
+	}
+
+	return searchResult{
+		hits:   hits,
+		total:  result.TotalHits() + int64(len(merch.pins)),
+		facets: facets,
+	}, nil
+}
+
+// similar looks for documents like the one with given id, the source document
+// itself is excluded from the result and filters of params are applied as in search
+func (ee *elasticEngine) similar(id string, params queryParams) ([]Doc, error) {
+	log.Infof("start similar query for %s with params: %+v", id, params)
+
+	exists, err := ee.client.Exists().
+		Index(ee.index).
+		Id(id).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while check doc exists")
+	}
+	if !exists {
+		return nil, errNotFound
+	}
+
+	esQuery := addFilters(elastic.NewMoreLikeThisQuery().
+		Field("name", "brand", "key_words").
+		LikeItems(elastic.NewMoreLikeThisQueryItem().Index(ee.index).Id(id)).
+		MinTermFreq(1).
+		MinDocFreq(1).
+		Include(false), params)
 
 	result, err := ee.client.Search().
-		Index(elasticIndexName).
+		Index(ee.index).
 		Query(esQuery).
-		SortBy(elastic.NewFieldSort(params.sortBy).Order(params.asc).SortMode("min")).
 		From(params.offset).Size(params.limit).
 		Do(context.Background())
 	if err != nil {
-		return nil, wrapper.Wrap(err, "error while do shoes query")
+		return nil, wrapper.Wrap(err, "error while do similar query")
 	}
 
-	res := []Doc{}
-	for _, hit := range result.Hits.Hits {
-		doc := Doc{}
-		if err := json.Unmarshal(hit.Source, &doc); err != nil {
-			return nil, wrapper.Wrap(err, "error while unmarshal elastic doc")
-		}
+	return docsFromHits(result)
+}
 
-		res = append(res, doc)
+// setPopularity updates popularity of the document without re-sending the whole document
+func (ee *elasticEngine) setPopularity(id string, popularity float64) error {
+	_, err := ee.partialUpdate(id, map[string]interface{}{"popularity": popularity})
+	return err
+}
+
+// partialUpdate changes only given fields of the document and returns the updated document
+func (ee *elasticEngine) partialUpdate(id string, fields map[string]interface{}) (Doc, error) {
+	resp, err := ee.client.Update().
+		Index(ee.index).
+		Id(id).
+		Doc(fields).
+		FetchSource(true).
+		RetryOnConflict(3).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return Doc{}, errNotFound
+	}
+	if err != nil {
+		return Doc{}, wrapper.Wrap(err, "error while update doc")
 	}
 
-	return res, nil
+	updated := Doc{}
+	if resp.GetResult == nil || json.Unmarshal(resp.GetResult.Source, &updated) != nil {
+		return Doc{}, errors.New("can't read updated doc")
+	}
+
+	ee.runHooks(docUpdated, []Doc{updated})
+	return updated, nil
 }
 
+// createIndex creates the documents index of the collection from the latest migration
 func (ee *elasticEngine) createIndex() error {
+	return ee.migrate()
+}
+
+func (ee *elasticEngine) ensureIndex(index, mapping string) error {
 	// Use the IndexExists service to check if a specified index exists.
-	exists, err := ee.client.IndexExists(elasticIndexName).Do(context.Background())
+	exists, err := ee.client.IndexExists(index).Do(context.Background())
 	if err != nil {
 		return wrapper.Wrap(err, "error while check index exists")
 	}
 	if !exists {
 		// Create a new index.
-		mapping := `
-{
-	"settings":{
-		"number_of_shards":1,
-		"number_of_replicas":0
-	},
-	"mappings":{
-			"properties":{
-				"id": {
-					"type":"text"
-				},
-				"created_at":{
-					"type":"date"
-				},
-				"name":{
-					"type":"text",
-					"store": true,
-					"fielddata": true
-				},
-				"brand":{
-					"type":"text",
-					"store": true,
-					"fielddata": true
-				},
-				"key_words":{
-					"type":"keyword"
-				}
-		}
-	}
-}
-`
-
-		_, err := ee.client.CreateIndex(elasticIndexName).Body(mapping).Do(context.Background())
+		_, err := ee.client.CreateIndex(index).Body(mapping).Do(context.Background())
 		if err != nil {
 			return wrapper.Wrap(err, "error while create index")
 		}
+
+		return nil
+	}
+
+	// fields added to the mapping after the index was created are put to it
+	body := struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}{}
+	if err := json.Unmarshal([]byte(mapping), &body); err != nil {
+		return wrapper.Wrap(err, "error while parse mapping")
+	}
+
+	if _, err := ee.client.PutMapping().Index(index).BodyString(string(body.Mappings)).Do(context.Background()); err != nil {
+		return wrapper.Wrap(err, "error while put mapping")
 	}
 
 	return nil
 }
 
+// deleteIndex removes the index or all versions of the alias
 func (ee *elasticEngine) deleteIndex(index string) error {
-	resp, err := ee.client.DeleteIndex(index).
+	indices, err := ee.aliasVersions(index)
+	if err != nil {
+		return err
+	}
+	if len(indices) == 0 {
+		indices = []string{index}
+	}
+
+	resp, err := ee.client.DeleteIndex(indices...).
 		Do(context.Background())
 	if err != nil {
 		return wrapper.Wrap(err, "error while delete index")
@@ -173,6 +489,65 @@ func (ee *elasticEngine) deleteIndex(index string) error {
 	return nil
 }
 
+// searchQuery matches documents by the query text and filters of params
+func searchQuery(params queryParams) elastic.Query {
+	var esQuery elastic.Query
+
+	switch params.q {
+	case "":
+		esQuery = elastic.NewMatchAllQuery()
+	default:
+		esQuery = elastic.NewMultiMatchQuery(params.q, "name", "brand", "key_words").
+			Fuzziness("2").
+			MinimumShouldMatch("2")
+	}
+
+	return addFilters(esQuery, params)
+}
+
+// addFilters narrows the query by all filters of params
+func addFilters(esQuery elastic.Query, params queryParams) elastic.Query {
+	esQuery = addFilter(esQuery, params.filterField, params.filterValue)
+	esQuery = addPriceFilter(esQuery, params)
+	esQuery = addCategoryFilter(esQuery, params.category)
+	esQuery = addVariantFilter(esQuery, params.variants)
+	esQuery = addStockFilter(esQuery, params)
+	esQuery = addGeoFilter(esQuery, params)
+	return addAttributeFilters(esQuery, params.attributes)
+}
+
+// sorter orders by the requested field, "_score" orders by relevance
+// and "distance" by distance to lat and lon
+func sorter(params queryParams) elastic.Sorter {
+	if params.sortBy == scoreSortField {
+		return elastic.NewScoreSort().Order(params.asc)
+	}
+
+	if params.sortBy == distanceSortField {
+		return distanceSort(params)
+	}
+
+	if params.sortBy == priceField {
+		return priceSort(params)
+	}
+
+	return elastic.NewFieldSort(params.sortBy).Order(params.asc).SortMode("min")
+}
+
+func docsFromHits(result *elastic.SearchResult) ([]Doc, error) {
+	res := []Doc{}
+	for _, hit := range result.Hits.Hits {
+		doc := Doc{}
+		if err := json.Unmarshal(hit.Source, &doc); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal elastic doc")
+		}
+
+		res = append(res, doc)
+	}
+
+	return res, nil
+}
+
 func addFilter(q elastic.Query, filter, with string) elastic.Query {
 	switch filter {
 	case "brand", "name", "key_words":
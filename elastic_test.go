@@ -12,14 +12,23 @@ const (
 	testElasticAddr = "http://localhost:9200"
 )
 
+// skipIntegration skips tests which need elasticsearch in -short mode of make unit-test
+func skipIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test needs elasticsearch")
+	}
+}
+
 func TestPut(t *testing.T) {
+	skipIntegration(t)
+
 	Convey("Check elastic put", t, func() {
-		engine, err := newElasticEngine(testElasticAddr)
+		engine, err := newElasticEngine(testElasticAddr, "")
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if err := engine.put([]Doc{
+		if _, err := engine.put([]Doc{
 			Doc{
 				Name:  "shoe",
 				Brand: "bkatrenko",
@@ -30,8 +39,8 @@ func TestPut(t *testing.T) {
 
 		<-time.After(time.Second)
 
-		//shoes, err := engine.get("shoe", "name", true, "", "", 1, 0)
-		shoes, err := engine.get(queryParams{
+		//result, err := engine.get("shoe", "name", true, "", "", 1, 0)
+		result, err := engine.get(queryParams{
 			q:      "shoe",
 			sortBy: "name",
 			asc:    true,
@@ -42,14 +51,27 @@ func TestPut(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		So(len(shoes), ShouldBeGreaterThan, 0)
-		So(shoes[0].Name, ShouldEqual, "shoe")
+		So(len(result.hits), ShouldBeGreaterThan, 0)
+		So(result.hits[0].Name, ShouldEqual, "shoe")
+	})
+}
+
+func TestUpdateFields(t *testing.T) {
+	Convey("Check update keeps popularity, id and created_at", t, func() {
+		fields, err := updateFields(Doc{ID: "1", Name: "shoe", Popularity: 0})
+		So(err, ShouldBeNil)
+		So(fields["name"], ShouldEqual, "shoe")
+		So(fields, ShouldNotContainKey, "popularity")
+		So(fields, ShouldNotContainKey, "id")
+		So(fields, ShouldNotContainKey, "created_at")
 	})
 }
 
 func TestSort(t *testing.T) {
+	skipIntegration(t)
+
 	Convey("Check elastic sort", t, func() {
-		engine, err := newElasticEngine(testElasticAddr)
+		engine, err := newElasticEngine(testElasticAddr, "")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -62,7 +84,7 @@ func TestSort(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := engine.put([]Doc{
+		if _, err := engine.put([]Doc{
 			Doc{
 				Name:  "a test sort",
 				Brand: "bkatrenko",
@@ -78,7 +100,7 @@ func TestSort(t *testing.T) {
 
 		<-time.After(time.Second)
 
-		shoes, err := engine.get(queryParams{
+		result, err := engine.get(queryParams{
 			q:      "test sort",
 			sortBy: "name",
 			asc:    true,
@@ -89,10 +111,10 @@ func TestSort(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		So(shoes[0].Name, ShouldEqual, "a test sort")
-		So(shoes[1].Name, ShouldEqual, "b test sort")
+		So(result.hits[0].Name, ShouldEqual, "a test sort")
+		So(result.hits[1].Name, ShouldEqual, "b test sort")
 
-		shoes, err = engine.get(queryParams{
+		result, err = engine.get(queryParams{
 			q:      "test sort",
 			sortBy: "name",
 			asc:    false,
@@ -103,14 +125,16 @@ func TestSort(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		So(shoes[0].Name, ShouldEqual, "b test sort")
-		So(shoes[1].Name, ShouldEqual, "a test sort")
+		So(result.hits[0].Name, ShouldEqual, "b test sort")
+		So(result.hits[1].Name, ShouldEqual, "a test sort")
 	})
 }
 
 func TestFilter(t *testing.T) {
+	skipIntegration(t)
+
 	Convey("Check elastic filter", t, func() {
-		engine, err := newElasticEngine(testElasticAddr)
+		engine, err := newElasticEngine(testElasticAddr, "")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -123,7 +147,7 @@ func TestFilter(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := engine.put([]Doc{
+		if _, err := engine.put([]Doc{
 			Doc{
 				Name:  "a test filter",
 				Brand: "bkatrenko",
@@ -139,7 +163,7 @@ func TestFilter(t *testing.T) {
 
 		<-time.After(time.Second)
 
-		shoes, err := engine.get(queryParams{
+		result, err := engine.get(queryParams{
 			q:           "test filter",
 			sortBy:      "name",
 			asc:         true,
@@ -152,7 +176,82 @@ func TestFilter(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		fmt.Println(shoes)
-		So(len(shoes), ShouldEqual, 1)
+		fmt.Println(result.hits)
+		So(len(result.hits), ShouldEqual, 1)
+	})
+}
+
+func TestSimilar(t *testing.T) {
+	skipIntegration(t)
+
+	Convey("Check elastic similar", t, func() {
+		engine, err := newElasticEngine(testElasticAddr, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := engine.deleteIndex(elasticIndexName); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := engine.createIndex(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := engine.put([]Doc{
+			Doc{
+				Name:     "running shoe",
+				Brand:    "bkatrenko",
+				KeyWords: []string{"summer", "sport"},
+			},
+
+			Doc{
+				Name:     "trail running shoe",
+				Brand:    "bkatrenko",
+				KeyWords: []string{"summer", "sport"},
+			},
+
+			Doc{
+				Name:     "winter jacket",
+				Brand:    "not me",
+				KeyWords: []string{"winter"},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		<-time.After(time.Second)
+
+		result, err := engine.get(queryParams{
+			q:      "running shoe",
+			sortBy: "name",
+			asc:    true,
+			limit:  1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(result.hits), ShouldEqual, 1)
+
+		shoes, err := engine.similar(result.hits[0].ID, queryParams{limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(shoes), ShouldBeGreaterThan, 0)
+		for _, shoe := range shoes {
+			So(shoe.ID, ShouldNotEqual, result.hits[0].ID)
+		}
+
+		shoes, err = engine.similar(result.hits[0].ID, queryParams{limit: 10, filterField: "brand", filterValue: "nobody"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(shoes), ShouldEqual, 0)
+
+		_, err = engine.similar("unknown", queryParams{limit: 10})
+		So(err, ShouldEqual, errNotFound)
 	})
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	sessionIDHeader  = "X-Session-ID"
+	experimentHeader = "X-Experiment"
+	variantHeader    = "X-Experiment-Variant"
+)
+
+// experiment splits searches between ranking configurations (search profiles),
+// the session is always assigned to the same variant
+type experiment struct {
+	Name     string    `json:"name"`
+	Enabled  bool      `json:"enabled"`
+	Variants []variant `json:"variants"`
+}
+
+// variant is a named search profile with the share of traffic
+type variant struct {
+	Name    string `json:"name"`
+	Profile string `json:"profile"`
+	Weight  int    `json:"weight"`
+}
+
+// VariantFeedback is a row of the experiment report
+type VariantFeedback struct {
+	Variant    string  `json:"variant"`
+	Searches   int64   `json:"searches"`
+	Clicks     int64   `json:"clicks"`
+	AddToCarts int64   `json:"add_to_carts"`
+	Purchases  int64   `json:"purchases"`
+	CTR        float64 `json:"ctr"`
+	MRR        float64 `json:"mrr"`
+}
+
+// readExperiments loads experiments from JSON file, empty path means no experiments
+func readExperiments(path string, profiles map[string]searchProfile) ([]experiment, error) {
+	experiments := []experiment{}
+	if path == "" {
+		return experiments, nil
+	}
+
+	experimentsBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while read experiments")
+	}
+
+	if err := json.Unmarshal(experimentsBytes, &experiments); err != nil {
+		return nil, wrapper.Wrap(err, "error while parse experiments")
+	}
+
+	for _, e := range experiments {
+		if err := e.validate(profiles); err != nil {
+			return nil, wrapper.Wrapf(err, "bad experiment %q", e.Name)
+		}
+	}
+
+	return experiments, nil
+}
+
+func (e *experiment) validate(profiles map[string]searchProfile) error {
+	if e.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if len(e.Variants) == 0 {
+		return errors.New("no variants")
+	}
+
+	names := map[string]bool{}
+	rankings := map[string]bool{}
+	for _, v := range e.Variants {
+		if v.Name == "" || names[v.Name] {
+			return errors.New("variant name is empty or not unique")
+		}
+		names[v.Name] = true
+
+		if v.Weight <= 0 {
+			return fmt.Errorf("variant %q weight should be greater than 0", v.Name)
+		}
+
+		if _, ok := profiles[v.Profile]; v.Profile != "" && !ok {
+			return fmt.Errorf("variant %q has unknown profile %q", v.Name, v.Profile)
+		}
+		rankings[v.Profile] = true
+	}
+
+	if len(e.Variants) > 1 && len(rankings) == 1 {
+		return errors.New("variants should use different profiles")
+	}
+
+	return nil
+}
+
+// assign picks the variant for the session key by hash of experiment name and key,
+// so the same session gets the same variant on every search and instance
+func (e *experiment) assign(key string) variant {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(e.Name + ":" + key))
+	bucket := int(hash.Sum32() % uint32(total))
+
+	for _, v := range e.Variants {
+		if bucket < v.Weight {
+			return v
+		}
+		bucket -= v.Weight
+	}
+
+	return e.Variants[len(e.Variants)-1]
+}
+
+// activeExperiment returns the first enabled experiment, experiments
+// can't run in parallel because all of them change the ranking
+func activeExperiment(experiments []experiment) (experiment, bool) {
+	for _, e := range experiments {
+		if e.Enabled {
+			return e, true
+		}
+	}
+
+	return experiment{}, false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadExperiments(t *testing.T) {
+	Convey("Check read experiments", t, func() {
+		profiles, err := readProfiles("profiles.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		experiments, err := readExperiments("experiments.json", profiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(experiments), ShouldBeGreaterThan, 0)
+
+		_, err = readExperiments("experiments.json", map[string]searchProfile{})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExperimentAssign(t *testing.T) {
+	Convey("Check deterministic variant assignment", t, func() {
+		e := experiment{
+			Name: "test",
+			Variants: []variant{
+				variant{Name: "a", Weight: 1},
+				variant{Name: "b", Weight: 3},
+			},
+		}
+
+		So(e.assign("session"), ShouldResemble, e.assign("session"))
+
+		assigned := map[string]int{}
+		for i := 0; i < 4000; i++ {
+			assigned[e.assign(fmt.Sprint(i)).Name]++
+		}
+
+		So(assigned["a"], ShouldBeBetween, 800, 1200)
+		So(assigned["b"], ShouldBeBetween, 2800, 3200)
+	})
+}
+
+func TestExperimentReport(t *testing.T) {
+	Convey("Check per variant report", t, func() {
+		sink := newMemoryAnalytics()
+		now := time.Now().UTC()
+
+		for _, search := range []SearchEvent{
+			SearchEvent{ID: "1", Time: now, Experiment: "test", Variant: "a"},
+			SearchEvent{ID: "2", Time: now, Experiment: "test", Variant: "b"},
+			SearchEvent{ID: "3", Time: now, Experiment: "test", Variant: "b"},
+			SearchEvent{ID: "4", Time: now, Experiment: "other", Variant: "a"},
+		} {
+			if err := sink.recordSearch(search); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := sink.recordFeedback(FeedbackEvent{
+			Time: now, Type: feedbackClick, SearchID: "2", Position: 2, Experiment: "test", Variant: "b",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err := sink.experimentReport("", "test", now.Add(-time.Hour), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(report, ShouldResemble, []VariantFeedback{
+			VariantFeedback{Variant: "b", Searches: 2, Clicks: 1, CTR: 0.5, MRR: 0.25},
+			VariantFeedback{Variant: "a", Searches: 1},
+		})
+	})
+}
+
+func TestAssignVariant(t *testing.T) {
+	Convey("Check searches sorted by field skip experiments", t, func() {
+		h := apiHandler{experiments: []experiment{experiment{
+			Name:    "test",
+			Enabled: true,
+			Variants: []variant{
+				variant{Name: "a", Profile: "default", Weight: 1},
+				variant{Name: "b", Profile: "popular", Weight: 1},
+			},
+		}}}
+
+		r := httptest.NewRequest("GET", "/v1/products", nil)
+		r.Header.Set(sessionIDHeader, "session")
+
+		params := queryParams{}
+		h.assignVariant(httptest.NewRecorder(), r, &params)
+		So(params.experiment, ShouldEqual, "test")
+		So(params.profile, ShouldNotBeEmpty)
+
+		params = queryParams{sortBy: "name"}
+		h.assignVariant(httptest.NewRecorder(), r, &params)
+		So(params.experiment, ShouldBeEmpty)
+		So(params.profile, ShouldBeEmpty)
+	})
+}
+
+func TestExperimentSameProfiles(t *testing.T) {
+	Convey("Check variants comparing the same ranking are rejected", t, func() {
+		e := experiment{
+			Name: "test",
+			Variants: []variant{
+				variant{Name: "a", Profile: "default", Weight: 1},
+				variant{Name: "b", Profile: "default", Weight: 1},
+			},
+		}
+
+		So(e.validate(map[string]searchProfile{"default": searchProfile{Name: "default"}}), ShouldNotBeNil)
+	})
+}
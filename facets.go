@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// facetSize is the max count of values returned for the facet
+const facetSize = 20
+
+// FacetBucket is a facet value with the count of found products having it,
+// buckets of numeric facets have bounds, from is inclusive and to is exclusive,
+// buckets of hierarchical facets have children
+type FacetBucket struct {
+	Value    interface{}   `json:"value"`
+	Count    int64         `json:"count"`
+	From     *float64      `json:"from,omitempty"`
+	To       *float64      `json:"to,omitempty"`
+	Children []FacetBucket `json:"children,omitempty"`
+}
+
+// facetAggregation counts values of the facet in found products,
+// facets are named by product attributes except built-in facets
+func facetAggregation(name string, params queryParams) elastic.Aggregation {
+	switch name {
+	case priceFacet:
+		return priceAggregation(params)
+	case categoryFacet:
+		return categoryAggregation()
+	}
+
+	return elastic.NewTermsAggregation().Field(attributeField(name)).Size(facetSize)
+}
+
+// facetBuckets reads the facet aggregation of the search result
+func facetBuckets(aggregations elastic.Aggregations, name string, params queryParams) ([]FacetBucket, error) {
+	switch name {
+	case priceFacet:
+		return priceBuckets(aggregations, params)
+	case categoryFacet:
+		return categoryBuckets(aggregations)
+	}
+
+	terms, ok := aggregations.Terms(name)
+	if !ok {
+		return nil, fmt.Errorf("no aggregation of facet %q", name)
+	}
+
+	buckets := make([]FacetBucket, 0, len(terms.Buckets))
+	for _, bucket := range terms.Buckets {
+		// booleans are aggregated as 1 and 0 with the string key
+		value := bucket.Key
+		if bucket.KeyAsString != nil {
+			value = *bucket.KeyAsString
+		}
+
+		buckets = append(buckets, FacetBucket{Value: value, Count: bucket.DocCount})
+	}
+
+	return buckets, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	feedbackClick     = "click"
+	feedbackAddToCart = "add_to_cart"
+	feedbackPurchase  = "purchase"
+
+	searchIDHeader = "X-Search-ID"
+)
+
+// FeedbackEvent is a user action on the search result, position is the rank
+// (starting from 1) of the product in the search, 0 when the product wasn't found
+type FeedbackEvent struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Collection string    `json:"collection"`
+	Type       string    `json:"type"`
+	SearchID   string    `json:"search_id"`
+	ProductID  string    `json:"product_id"`
+	Query      string    `json:"query"`
+	Position   int       `json:"position"`
+	Source     string    `json:"source"`
+	Experiment string    `json:"experiment,omitempty"`
+	Variant    string    `json:"variant,omitempty"`
+}
+
+// QueryFeedback is a row of the feedback report
+type QueryFeedback struct {
+	Query      string  `json:"query"`
+	Searches   int64   `json:"searches"`
+	Clicks     int64   `json:"clicks"`
+	AddToCarts int64   `json:"add_to_carts"`
+	Purchases  int64   `json:"purchases"`
+	CTR        float64 `json:"ctr"`
+	MRR        float64 `json:"mrr"`
+}
+
+func (fe *FeedbackEvent) validate() error {
+	switch fe.Type {
+	case feedbackClick, feedbackAddToCart, feedbackPurchase:
+	default:
+		return errors.New("unknown event type")
+	}
+
+	if fe.SearchID == "" || fe.ProductID == "" {
+		return errors.New("search_id and product_id are required")
+	}
+
+	return nil
+}
+
+// position returns the rank of the product in the search result
+func (se *SearchEvent) position(productID string) int {
+	for i, id := range se.Results {
+		if id == productID {
+			return se.Offset + i + 1
+		}
+	}
+
+	return 0
+}
+
+// feedbackCounts is the raw data of the feedback report collected by the sink
+type feedbackCounts struct {
+	// searches per query
+	searches map[string]int64
+	// events per query and event type
+	events map[string]map[string]int64
+	// best ranked click on the result per query and search
+	firstClicks map[string]map[string]int
+}
+
+func newFeedbackCounts() feedbackCounts {
+	return feedbackCounts{
+		searches:    map[string]int64{},
+		events:      map[string]map[string]int64{},
+		firstClicks: map[string]map[string]int{},
+	}
+}
+
+func (fc feedbackCounts) addEvent(query, eventType string, count int64) {
+	if fc.events[query] == nil {
+		fc.events[query] = map[string]int64{}
+	}
+
+	fc.events[query][eventType] += count
+}
+
+func (fc feedbackCounts) addClick(query, searchID string, position int) {
+	if fc.firstClicks[query] == nil {
+		fc.firstClicks[query] = map[string]int{}
+	}
+
+	best, ok := fc.firstClicks[query][searchID]
+	if !ok || position < best {
+		fc.firstClicks[query][searchID] = position
+	}
+}
+
+// report calculates CTR as the share of searches with a click and MRR as the mean
+// of reciprocal ranks of the best ranked click, searches without click count as 0
+func (fc feedbackCounts) report(limit int) []QueryFeedback {
+	report := make([]QueryFeedback, 0, len(fc.searches))
+	for query, searches := range fc.searches {
+		if searches == 0 {
+			continue
+		}
+
+		row := QueryFeedback{
+			Query:      query,
+			Searches:   searches,
+			Clicks:     fc.events[query][feedbackClick],
+			AddToCarts: fc.events[query][feedbackAddToCart],
+			Purchases:  fc.events[query][feedbackPurchase],
+		}
+
+		reciprocalRanks := 0.0
+		for _, position := range fc.firstClicks[query] {
+			reciprocalRanks += 1 / float64(position)
+		}
+
+		row.CTR = float64(len(fc.firstClicks[query])) / float64(searches)
+		row.MRR = reciprocalRanks / float64(searches)
+		report = append(report, row)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Searches == report[j].Searches {
+			return report[i].Query < report[j].Query
+		}
+
+		return report[i].Searches > report[j].Searches
+	})
+
+	if len(report) > limit {
+		report = report[:limit]
+	}
+
+	return report
+}
+
+// variantReport converts the report grouped by variant instead of query
+func variantReport(rows []QueryFeedback) []VariantFeedback {
+	report := make([]VariantFeedback, 0, len(rows))
+	for _, row := range rows {
+		report = append(report, VariantFeedback{
+			Variant:    row.Query,
+			Searches:   row.Searches,
+			Clicks:     row.Clicks,
+			AddToCarts: row.AddToCarts,
+			Purchases:  row.Purchases,
+			CTR:        row.CTR,
+			MRR:        row.MRR,
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSearchPosition(t *testing.T) {
+	Convey("Check product position in the search", t, func() {
+		search := SearchEvent{Offset: 10, Results: []string{"a", "b"}}
+
+		So(search.position("a"), ShouldEqual, 11)
+		So(search.position("b"), ShouldEqual, 12)
+		So(search.position("c"), ShouldEqual, 0)
+	})
+}
+
+func TestFeedbackReport(t *testing.T) {
+	Convey("Check CTR and MRR report", t, func() {
+		sink := newMemoryAnalytics()
+		now := time.Now().UTC()
+
+		for _, search := range []SearchEvent{
+			SearchEvent{ID: "1", Time: now, Query: "shoe"},
+			SearchEvent{ID: "2", Time: now, Query: "shoe"},
+			SearchEvent{ID: "3", Time: now, Query: "shoe"},
+			SearchEvent{ID: "4", Time: now, Query: "shoe"},
+			SearchEvent{ID: "5", Time: now, Query: "boot"},
+		} {
+			if err := sink.recordSearch(search); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, event := range []FeedbackEvent{
+			FeedbackEvent{Time: now, Type: feedbackClick, SearchID: "1", Query: "shoe", Position: 2},
+			FeedbackEvent{Time: now, Type: feedbackClick, SearchID: "1", Query: "shoe", Position: 1},
+			FeedbackEvent{Time: now, Type: feedbackClick, SearchID: "2", Query: "shoe", Position: 4},
+			FeedbackEvent{Time: now, Type: feedbackClick, SearchID: "3", Query: "shoe", Position: 0},
+			FeedbackEvent{Time: now, Type: feedbackPurchase, SearchID: "1", Query: "shoe", Position: 1},
+		} {
+			if err := sink.recordFeedback(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		report, err := sink.feedbackReport("", now.Add(-time.Hour), now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(report), ShouldEqual, 2)
+		So(report[0].Query, ShouldEqual, "shoe")
+		So(report[0].Searches, ShouldEqual, 4)
+		So(report[0].Clicks, ShouldEqual, 4)
+		So(report[0].Purchases, ShouldEqual, 1)
+		So(report[0].CTR, ShouldAlmostEqual, 0.5)
+		So(report[0].MRR, ShouldAlmostEqual, (1+0.25)/4)
+		So(report[1].Query, ShouldEqual, "boot")
+		So(report[1].CTR, ShouldEqual, 0)
+
+		search, err := sink.search("5")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(search.Query, ShouldEqual, "boot")
+
+		_, err = sink.search("6")
+		So(err, ShouldEqual, errNotFound)
+	})
+}
+
+func TestPostEventV1(t *testing.T) {
+	Convey("Check feedback is accepted only on searches of the token collection", t, func() {
+		h := apiHandler{elastic: elasticEngine{collection: defaultCollectionName}, analytics: newQueuedAnalytics(newMemoryAnalytics())}
+		So(h.analytics.recordSearch(SearchEvent{ID: "legacy", Results: []string{"1"}}), ShouldBeNil)
+		So(h.analytics.recordSearch(SearchEvent{ID: "acme", Collection: "acme", Results: []string{"1"}}), ShouldBeNil)
+
+		post := func(searchID string) int {
+			body := `{"type":"click","search_id":"` + searchID + `","product_id":"1"}`
+			r := httptest.NewRequest("POST", "/v1/events", strings.NewReader(body))
+			ctx := context.WithValue(r.Context(), claimsContextKey, tokenClaims{Info: tokenInfo{Source: "shop"}})
+			rr := httptest.NewRecorder()
+			h.postEventV1(rr, r.WithContext(ctx))
+			return rr.Code
+		}
+
+		So(post("legacy"), ShouldEqual, http.StatusNoContent)
+		So(post("acme"), ShouldEqual, http.StatusNotFound)
+
+		report, err := h.analytics.feedbackReport("acme", time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 10)
+		So(err, ShouldBeNil)
+		So(len(report), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/olivere/elastic/v7"
+)
+
+const (
+	locationsField = "locations"
+	// distanceSortField orders products by distance to the nearest location
+	distanceSortField = "distance"
+	distanceUnit      = "km"
+)
+
+var radiusRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|km|mi)?$`)
+
+// GeoPoint is a location of the product or the store
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func (gp *GeoPoint) validate() error {
+	if gp.Lat < -90 || gp.Lat > 90 || gp.Lon < -180 || gp.Lon > 180 {
+		return errors.New("lat should be from -90 to 90 and lon from -180 to 180")
+	}
+
+	return nil
+}
+
+// validateLocations checks locations of the product and its stores
+func (d *Doc) validateLocations() error {
+	if d.Location != nil {
+		if err := d.Location.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, store := range d.Stores {
+		if store.Location != nil {
+			if err := store.Location.validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// setLocations indexes the product location with locations of its stores,
+// so the product is found and sorted by the nearest of them
+func (d *Doc) setLocations() {
+	d.Locations = productLocations(d.Location, d.Stores)
+}
+
+// productLocations returns the product location with locations of its stores
+func productLocations(location *GeoPoint, stores []StoreStock) []GeoPoint {
+	var locations []GeoPoint
+	if location != nil {
+		locations = append(locations, *location)
+	}
+
+	for _, store := range stores {
+		if store.Location != nil {
+			locations = append(locations, *store.Location)
+		}
+	}
+
+	return locations
+}
+
+// parseRadius checks the distance has known unit, km is the default one
+func parseRadius(radius string) (string, error) {
+	if !radiusRegexp.MatchString(radius) {
+		return "", errors.New("radius should be a distance like 500m, 10km or 5mi")
+	}
+
+	if _, err := strconv.ParseFloat(radius, 64); err == nil {
+		return radius + distanceUnit, nil
+	}
+
+	return radius, nil
+}
+
+// addGeoFilter narrows the query to products within the radius, with in_stock=true or the store
+// only the store having the product in stock or the store of the query is considered
+func addGeoFilter(q elastic.Query, params queryParams) elastic.Query {
+	if params.geo == nil || params.radius == "" {
+		return q
+	}
+
+	query := elastic.NewBoolQuery().Must(q)
+	if stores, ok := storesQuery(params); ok {
+		return query.Filter(elastic.NewNestedQuery(storesField, stores))
+	}
+
+	return query.Filter(elastic.NewGeoDistanceQuery(locationsField).Point(params.geo.Lat, params.geo.Lon).Distance(params.radius))
+}
+
+// distanceSort orders by distance in km to the nearest location of the product,
+// the nearest products are the first ones. Products found by the store are sorted
+// by the nearest of matching stores, so out of stock stores don't count
+func distanceSort(params queryParams) elastic.Sorter {
+	if stores, ok := storesQuery(params); ok {
+		return elastic.NewGeoDistanceSort(storesField+".location").
+			Point(params.geo.Lat, params.geo.Lon).
+			Unit(distanceUnit).
+			SortMode("min").
+			NestedSort(elastic.NewNestedSort(storesField).Filter(stores)).
+			Asc()
+	}
+
+	return elastic.NewGeoDistanceSort(locationsField).
+		Point(params.geo.Lat, params.geo.Lon).
+		Unit(distanceUnit).
+		SortMode("min").
+		Asc()
+}
+
+// hitDistance returns the distance sort value of the hit, it's the last one
+func hitDistance(hit *elastic.SearchHit) (float64, bool) {
+	if len(hit.Sort) == 0 {
+		return 0, false
+	}
+
+	distance, ok := hit.Sort[len(hit.Sort)-1].(float64)
+	return distance, ok
+}
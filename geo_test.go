@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGeoParams(t *testing.T) {
+	Convey("Check lat, lon and radius params", t, func() {
+		params := queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?lat=52.52&lon=13.40&radius=10&sort_by=distance", nil)), ShouldBeNil)
+		So(params.validate(), ShouldBeNil)
+		So(*params.geo, ShouldResemble, GeoPoint{Lat: 52.52, Lon: 13.40})
+		So(params.radius, ShouldEqual, "10km")
+
+		radius, err := parseRadius("500m")
+		So(err, ShouldBeNil)
+		So(radius, ShouldEqual, "500m")
+
+		_, err = parseRadius("far")
+		So(err, ShouldNotBeNil)
+
+		params = queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?sort_by=distance", nil)), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+
+		params = queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?lat=100&lon=13", nil)), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+
+		So((&queryParams{}).fill(httptest.NewRequest("GET", "/v1/products?lat=52.52", nil)), ShouldNotBeNil)
+	})
+}
+
+func TestGeoFilter(t *testing.T) {
+	Convey("Check products are found by product and store locations", t, func() {
+		doc := Doc{Location: &GeoPoint{Lat: 1, Lon: 2}, Stores: []StoreStock{{StoreID: "a", Location: &GeoPoint{Lat: 3, Lon: 4}}, {StoreID: "b"}}}
+		So(doc.validate(), ShouldBeNil)
+		doc.setLocations()
+		So(doc.Locations, ShouldResemble, []GeoPoint{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4}})
+
+		inStock := true
+		params := queryParams{geo: &GeoPoint{Lat: 52.52, Lon: 13.40}, radius: "5km"}
+		So(querySource(t, addGeoFilter(elastic.NewMatchAllQuery(), params)), ShouldContainSubstring, `"geo_distance":{"distance":"5km","locations":{"lat":52.52,"lon":13.4}}`)
+
+		params.inStock = &inStock
+		body := querySource(t, addGeoFilter(elastic.NewMatchAllQuery(), params))
+		So(body, ShouldContainSubstring, `"nested":{"path":"stores"`)
+		So(body, ShouldContainSubstring, `"stores.location":{"lat":52.52,"lon":13.4}`)
+
+		params.store = "berlin-1"
+		So(querySource(t, addStockFilter(elastic.NewMatchAllQuery(), params)), ShouldEqual, `{"match_all":{}}`)
+		body = querySource(t, addGeoFilter(elastic.NewMatchAllQuery(), params))
+		So(body, ShouldContainSubstring, `{"term":{"stores.store_id":"berlin-1"}},{"term":{"stores.availability":"in_stock"}},{"geo_distance"`)
+
+		sort, err := distanceSort(params).Source()
+		So(err, ShouldBeNil)
+		So(sort, ShouldContainKey, "_geo_distance")
+		So(sort.(map[string]interface{})["_geo_distance"], ShouldContainKey, "nested")
+
+		So(productLocations(nil, doc.Stores), ShouldResemble, []GeoPoint{{Lat: 3, Lon: 4}})
+
+		hit := &elastic.SearchHit{Sort: []interface{}{"a name", 1.5}}
+		distance, ok := hitDistance(hit)
+		So(ok, ShouldBeTrue)
+		So(distance, ShouldEqual, 1.5)
+	})
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"crypto/rsa"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -16,50 +15,188 @@ import (
 )
 
 type apiHandler struct {
-	signKey   *rsa.PrivateKey
-	verifyKey *rsa.PublicKey
-	router    *mux.Router
+	signKey    signingKey
+	verifyKeys keySet
+	router     *mux.Router
 
-	clientCreds   string
-	tokenExpireAt int64
+	clientCreds     string
+	tokenExpireAt   int64
+	refreshExpireAt int64
+	revocations     revocationStore
+
+	profiles    map[string]searchProfile
+	experiments []experiment
+	analytics   *queuedAnalytics
 
 	elastic elasticEngine
 }
 
-func newAPIHandler(elasticAddr, privateKey, publicKey string, tokenExpireAt string) (apiHandler, error) {
-	log.Info("connect to elastic on addr: ", elasticAddr)
-	elastic, err := newElasticEngine(elasticAddr)
+// apiConfig configures handlers, values are read from the environment, see main.go.
+// Empty optional values turn the feature off or use its default
+type apiConfig struct {
+	ElasticAddr    string
+	MigrationsPath string
+
+	SignAlg         string
+	SignKey         string
+	VerifyKeys      string
+	TokenExpireAt   string
+	RefreshExpireAt string
+	TokenStore      string
+
+	ProfilesPath       string
+	ExperimentsPath    string
+	AnalyticsKind      string
+	SavedSearchWebhook string
+	SavedSearchSecret  string
+}
+
+func newAPIHandler(config apiConfig) (apiHandler, error) {
+	log.Info("connect to elastic on addr: ", config.ElasticAddr)
+	elastic, err := newElasticEngine(config.ElasticAddr, config.MigrationsPath)
 	if err != nil {
 		return apiHandler{}, wrapper.Wrap(err, "can't create elastic engine")
 	}
 
-	expireAt, err := strconv.ParseInt(tokenExpireAt, 10, 64)
+	if err := elastic.migrateCollections(); err != nil {
+		return apiHandler{}, wrapper.Wrap(err, "error while migrate collections")
+	}
+
+	expireAt, err := strconv.ParseInt(config.TokenExpireAt, 10, 64)
 	if err != nil {
 		return apiHandler{}, wrapper.Wrap(err, "error while parse expire at")
 	}
 
+	refreshExpire := int64(defaultRefreshExpireAt)
+	if config.RefreshExpireAt != "" {
+		if refreshExpire, err = strconv.ParseInt(config.RefreshExpireAt, 10, 64); err != nil {
+			return apiHandler{}, wrapper.Wrap(err, "error while parse refresh expire at")
+		}
+	}
+
+	revocations, err := newRevocationStore(config.TokenStore, elastic)
+	if err != nil {
+		return apiHandler{}, wrapper.Wrap(err, "error while create token store")
+	}
+
+	profiles, err := readProfiles(config.ProfilesPath)
+	if err != nil {
+		return apiHandler{}, wrapper.Wrap(err, "error while read search profiles")
+	}
+
+	experiments, err := readExperiments(config.ExperimentsPath, profiles)
+	if err != nil {
+		return apiHandler{}, wrapper.Wrap(err, "error while read experiments")
+	}
+
+	sink, err := newAnalyticsSink(config.AnalyticsKind, elastic)
+	if err != nil {
+		return apiHandler{}, wrapper.Wrap(err, "error while create analytics sink")
+	}
+	analytics := newQueuedAnalytics(sink)
+
+	elastic.onIndexed(newWebhookDispatcher(elastic, newWebhookSender()).notify)
+
+	if config.SavedSearchWebhook != "" {
+		notifier, err := newSavedSearchNotifier(elastic, newWebhookSender(), config.SavedSearchWebhook, config.SavedSearchSecret)
+		if err != nil {
+			return apiHandler{}, wrapper.Wrap(err, "error while create saved search notifier")
+		}
+		elastic.onIndexed(notifier.notify)
+	}
+
 	handlers := apiHandler{
-		router:        mux.NewRouter(),
-		elastic:       elastic,
-		tokenExpireAt: expireAt,
+		router:          mux.NewRouter(),
+		elastic:         elastic,
+		tokenExpireAt:   expireAt,
+		refreshExpireAt: refreshExpire,
+		revocations:     revocations,
+		profiles:        profiles,
+		experiments:     experiments,
+		analytics:       analytics,
 	}
 
-	if err := handlers.readKeys(privateKey, publicKey); err != nil {
+	if err := handlers.readKeys(config.SignAlg, config.SignKey, config.VerifyKeys); err != nil {
 		return apiHandler{}, wrapper.Wrap(err, "error while read token keys")
 	}
 
+	handlers.addWellKnownRoutes()
 	handlers.addV1Routes()
+	handlers.addV2Routes()
 	return handlers, nil
 }
 
+// close waits for background work of handlers, it's called after the server stopped
+func (h *apiHandler) close() {
+	h.analytics.close()
+}
+
+// addWellKnownRoutes adds public routes of RFC 8615 which are not versioned
+func (h *apiHandler) addWellKnownRoutes() {
+	h.router.HandleFunc("/.well-known/jwks.json", h.getJWKS).Methods("GET")
+}
+
 func (h *apiHandler) addV1Routes() {
 	r := h.router.PathPrefix("/v1").Subrouter()
 	r.HandleFunc("/auth", h.getTokenV1).Methods("GET")
-	r.HandleFunc("/products", h.getProductsV1).Methods("GET")
-	r.HandleFunc("/products", h.postProductV1).Methods("POST")
+	r.HandleFunc("/oauth/token", h.postOAuthTokenV1).Methods("POST")
+	r.HandleFunc("/oauth/revoke", h.postOAuthRevokeV1).Methods("POST")
+	r.HandleFunc("/oauth/introspect", h.postOAuthIntrospectV1).Methods("POST")
+	h.addCollectionRoutes(r)
+	h.addCollectionRoutes(r.PathPrefix("/collections/{name}").Subrouter())
+	r.HandleFunc("/admin/clients", h.requireScope(scopeAdmin, h.getClientsV1)).Methods("GET")
+	r.HandleFunc("/admin/clients", h.requireScope(scopeAdmin, h.postClientV1)).Methods("POST")
+	r.HandleFunc("/admin/clients/{id}", h.requireScope(scopeAdmin, h.putClientV1)).Methods("PUT")
+	r.HandleFunc("/admin/clients/{id}/secret", h.requireScope(scopeAdmin, h.postClientSecretV1)).Methods("POST")
+	r.HandleFunc("/admin/api-keys", h.requireScope(scopeAdmin, h.getAPIKeysV1)).Methods("GET")
+	r.HandleFunc("/admin/api-keys", h.requireScope(scopeAdmin, h.postAPIKeyV1)).Methods("POST")
+	r.HandleFunc("/admin/api-keys/{id}", h.requireScope(scopeAdmin, h.deleteAPIKeyV1)).Methods("DELETE")
+	r.HandleFunc("/admin/collections", h.requireScope(scopeAdmin, h.getCollectionsV1)).Methods("GET")
+	r.HandleFunc("/admin/collections", h.requireScope(scopeAdmin, h.postCollectionV1)).Methods("POST")
+	r.HandleFunc("/admin/collections/{name}", h.requireScope(scopeAdmin, h.deleteCollectionV1)).Methods("DELETE")
+	r.HandleFunc("/admin/analytics/top-queries", h.requireScope(scopeAdmin, h.getTopQueriesV1)).Methods("GET")
+	r.HandleFunc("/admin/analytics/zero-results", h.requireScope(scopeAdmin, h.getZeroResultsV1)).Methods("GET")
+	r.HandleFunc("/admin/analytics/feedback", h.requireScope(scopeAdmin, h.getFeedbackReportV1)).Methods("GET")
+	r.HandleFunc("/admin/analytics/experiments/{name}", h.requireScope(scopeAdmin, h.getExperimentReportV1)).Methods("GET")
+	r.HandleFunc("/admin/webhooks", h.requireScope(scopeAdmin, h.getWebhooksV1)).Methods("GET")
+	r.HandleFunc("/admin/webhooks", h.requireScope(scopeAdmin, h.postWebhookV1)).Methods("POST")
+	r.HandleFunc("/admin/webhooks/dead-letters", h.requireScope(scopeAdmin, h.getDeadLettersV1)).Methods("GET")
+	r.HandleFunc("/admin/webhooks/{id}", h.requireScope(scopeAdmin, h.deleteWebhookV1)).Methods("DELETE")
 	r.Use(h.authMiddleware, h.loggingMiddleware)
 }
 
+// addV2Routes adds routes which changed the response format since v1,
+// other routes are the same in both versions
+func (h *apiHandler) addV2Routes() {
+	r := h.router.PathPrefix("/v2").Subrouter()
+	r.HandleFunc("/products", h.requireScope(scopeProductsRead, h.getProductsV2)).Methods("GET")
+	r.HandleFunc("/collections/{name}/products", h.requireScope(scopeProductsRead, h.getProductsV2)).Methods("GET")
+	r.Use(h.authMiddleware, h.loggingMiddleware)
+}
+
+// addCollectionRoutes adds routes working with documents of one collection,
+// see engine for how the collection is chosen
+func (h *apiHandler) addCollectionRoutes(r *mux.Router) {
+	r.HandleFunc("/products", h.requireScope(scopeProductsRead, h.getProductsV1)).Methods("GET")
+	r.HandleFunc("/products", h.requireScope(scopeProductsWrite, h.postProductV1)).Methods("POST")
+	r.HandleFunc("/products/stock", h.requireScope(scopeProductsWrite, h.postStockV1)).Methods("POST")
+	r.HandleFunc("/products/{id}", h.requireScope(scopeProductsWrite, h.putProductV1)).Methods("PUT")
+	r.HandleFunc("/products/{id}", h.requireScope(scopeProductsWrite, h.deleteProductV1)).Methods("DELETE")
+	r.HandleFunc("/products/{id}/similar", h.requireScope(scopeProductsRead, h.getSimilarProductsV1)).Methods("GET")
+	r.HandleFunc("/products/{id}/popularity", h.requireScope(scopeProductsWrite, h.putPopularityV1)).Methods("PUT")
+	r.HandleFunc("/events", h.requireScope(scopeProductsRead, h.postEventV1)).Methods("POST")
+	r.HandleFunc("/saved-searches", h.requireScope(scopeProductsRead, h.getSavedSearchesV1)).Methods("GET")
+	r.HandleFunc("/saved-searches", h.requireScope(scopeProductsRead, h.postSavedSearchV1)).Methods("POST")
+	r.HandleFunc("/saved-searches/{id}", h.requireScope(scopeProductsRead, h.deleteSavedSearchV1)).Methods("DELETE")
+	r.HandleFunc("/admin/rules", h.requireScope(scopeAdmin, h.getRulesV1)).Methods("GET")
+	r.HandleFunc("/admin/rules", h.requireScope(scopeAdmin, h.postRuleV1)).Methods("POST")
+	r.HandleFunc("/admin/rules/{id}", h.requireScope(scopeAdmin, h.putRuleV1)).Methods("PUT")
+	r.HandleFunc("/admin/rules/{id}", h.requireScope(scopeAdmin, h.deleteRuleV1)).Methods("DELETE")
+	r.HandleFunc("/categories", h.requireScope(scopeProductsRead, h.getCategoriesV1)).Methods("GET")
+	r.HandleFunc("/admin/categories", h.requireScope(scopeAdmin, h.postCategoryV1)).Methods("POST")
+	r.HandleFunc("/admin/categories/{path:.+}", h.requireScope(scopeAdmin, h.deleteCategoryV1)).Methods("DELETE")
+}
+
 func (h *apiHandler) getTokenV1(w http.ResponseWriter, r *http.Request) {
 	creds, ok := r.URL.Query()["creds"]
 	if !ok || len(creds) != 1 {
@@ -68,13 +205,33 @@ func (h *apiHandler) getTokenV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if creds[0] != os.Getenv("CLIENT_CREDS") {
-		log.Info("can't get token: bad creds")
+	id, secret, err := parseCredentials(creds[0])
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad request: "+err.Error())
+		return
+	}
+
+	client, err := h.authenticate(id, secret)
+	if err == errBadCredentials {
+		log.Infof("can't get token: bad creds of client %s", id)
 		h.writeError(w, http.StatusNotFound, "bad creds")
 		return
 	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	scopes, err := client.grantedScopes("")
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
 
-	token, err := makeToken(h.signKey, "searchapi", time.Now().Add(time.Duration(h.tokenExpireAt)*time.Second).Unix())
+	token, err := h.issueToken(client, scopes)
 	if err != nil {
 		log.Errorf("error while make new token: %v", err)
 		h.writeError(w, http.StatusInternalServerError, "internal error")
@@ -84,7 +241,91 @@ func (h *apiHandler) getTokenV1(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, Token{token})
 }
 
+// getProductsV1 returns the array of hits, facets are returned only by v2
 func (h *apiHandler) getProductsV1(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("facets") != "" {
+		h.writeError(w, http.StatusBadRequest, "facets are returned by /v2/products")
+		return
+	}
+
+	result, ok := h.search(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, result.hits); err != nil {
+		log.Error(err)
+	}
+}
+
+// getProductsV2 returns the search response object with the search id and total
+// whether facets are requested or not
+func (h *apiHandler) getProductsV2(w http.ResponseWriter, r *http.Request) {
+	result, ok := h.search(w, r)
+	if !ok {
+		return
+	}
+
+	response := SearchResponse{
+		SearchID: w.Header().Get(searchIDHeader),
+		Hits:     result.hits,
+		Total:    result.total,
+		Facets:   result.facets,
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, response); err != nil {
+		log.Error(err)
+	}
+}
+
+// search finds products of the collection by query params and records the search,
+// the search id is set to the X-Search-ID header
+func (h *apiHandler) search(w http.ResponseWriter, r *http.Request) (searchResult, bool) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return searchResult{}, false
+	}
+
+	params := queryParams{}
+	if err := params.fill(r); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad query")
+		return searchResult{}, false
+	}
+
+	if err := params.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return searchResult{}, false
+	}
+
+	h.assignVariant(w, r, &params)
+
+	if err := h.resolveProfile(&params); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return searchResult{}, false
+	}
+
+	start := time.Now()
+	result, err := engine.get(params)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return searchResult{}, false
+	}
+
+	searchID := h.recordSearch(r, engine.collection, params, result, time.Since(start))
+	w.Header().Set(searchIDHeader, searchID)
+	return result, true
+}
+
+func (h *apiHandler) getSimilarProductsV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
 	params := queryParams{}
 	if err := params.fill(r); err != nil {
 		log.Error(err)
@@ -98,7 +339,11 @@ func (h *apiHandler) getProductsV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	docs, err := h.elastic.get(params)
+	docs, err := engine.similar(mux.Vars(r)["id"], params)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "product not found")
+		return
+	}
 	if err != nil {
 		log.Error(err)
 		h.writeError(w, http.StatusInternalServerError, "internal error")
@@ -110,7 +355,44 @@ func (h *apiHandler) getProductsV1(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *apiHandler) putPopularityV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	popularity := Popularity{}
+	if err := json.NewDecoder(r.Body).Decode(&popularity); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := validatePopularity(popularity.Popularity); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := engine.setPopularity(mux.Vars(r)["id"], popularity.Popularity)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "product not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *apiHandler) postProductV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
 	docs := []Doc{}
 
 	if err := json.NewDecoder(r.Body).Decode(&docs); err != nil {
@@ -119,11 +401,174 @@ func (h *apiHandler) postProductV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.elastic.put(docs); err != nil {
+	for _, doc := range docs {
+		if err := doc.validate(); err != nil {
+			log.Error(err)
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if !h.knownCategories(w, engine, docs) || !h.consistentAttributes(w, engine, docs) {
+		return
+	}
+
+	result, err := engine.put(docs)
+	if err != nil {
 		log.Error(err)
 		h.writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+
+	code := http.StatusOK
+	if len(result.Failed) > 0 {
+		log.Errorf("%d of %d products are not stored", len(result.Failed), len(docs))
+		code = http.StatusUnprocessableEntity
+	}
+
+	if err := h.writeJSON(w, code, result); err != nil {
+		log.Error(err)
+	}
+}
+
+// assignVariant puts the session into the variant of the active experiment,
+// explicitly requested profile and requests without session skip experiments.
+// Searches sorted by a field skip them too, profiles don't change their order
+func (h *apiHandler) assignVariant(w http.ResponseWriter, r *http.Request, params *queryParams) {
+	session := r.Header.Get(sessionIDHeader)
+	if params.profile != "" || session == "" {
+		return
+	}
+
+	if params.sortBy != "" && params.sortBy != scoreSortField {
+		return
+	}
+
+	e, ok := activeExperiment(h.experiments)
+	if !ok {
+		return
+	}
+
+	v := e.assign(session)
+	params.profile = v.Profile
+	params.experiment = e.Name
+	params.variant = v.Name
+
+	w.Header().Set(experimentHeader, e.Name)
+	w.Header().Set(variantHeader, v.Name)
+}
+
+// resolveProfile sets ranking of the requested search profile,
+// default profile is used when no profile requested
+func (h *apiHandler) resolveProfile(params *queryParams) error {
+	name := params.profile
+	if name == "" {
+		name = defaultProfileName
+	}
+
+	profile, ok := h.profiles[name]
+	switch {
+	case ok:
+		params.ranking = &profile
+	case params.profile != "":
+		return fmt.Errorf("unknown search profile %q", params.profile)
+	}
+
+	return nil
+}
+
+func (h *apiHandler) putProductV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	doc := Doc{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := doc.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.knownCategories(w, engine, []Doc{doc}) || !h.consistentAttributes(w, engine, []Doc{doc}) {
+		return
+	}
+
+	doc, err := engine.update(mux.Vars(r)["id"], doc)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "product not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, doc); err != nil {
+		log.Error(err)
+	}
+}
+
+// consistentAttributes responds with bad request when attributes of products have
+// another type than in indexed products or in other products of the request
+func (h *apiHandler) consistentAttributes(w http.ResponseWriter, engine elasticEngine, docs []Doc) bool {
+	types, err := engine.attributeTypes()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+
+	if conflicts := attributeTypeConflicts(docs, types); len(conflicts) > 0 {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("attributes of another type: %s", strings.Join(conflicts, ", ")))
+		return false
+	}
+
+	return true
+}
+
+func (h *apiHandler) deleteProductV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	err := engine.delete(mux.Vars(r)["id"])
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "product not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueToken makes the access token of the client with the granted scopes,
+// which expires in tokenExpireAt seconds
+func (h *apiHandler) issueToken(client Client, scopes []string) (string, error) {
+	info := tokenInfo{Source: client.ID, Tenant: client.Tenant, Scopes: scopes, Generation: client.Generation}
+	return signToken(h.signKey, newTokenClaims(tokenType, info, time.Now().Add(time.Duration(h.tokenExpireAt)*time.Second).Unix()))
+}
+
+// tokensLifetime is how long the latest issued access or refresh token stays valid
+func (h *apiHandler) tokensLifetime() time.Duration {
+	lifetime := h.tokenExpireAt
+	if h.refreshExpireAt > lifetime {
+		lifetime = h.refreshExpireAt
+	}
+
+	return time.Duration(lifetime) * time.Second
 }
 
 func (h *apiHandler) writeError(w http.ResponseWriter, code int, err string) {
@@ -132,8 +577,9 @@ func (h *apiHandler) writeError(w http.ResponseWriter, code int, err string) {
 	w.Write([]byte(fmt.Sprintf("{\"error\": \"%s\"}", err)))
 }
 
-func (h *apiHandler) writeJSON(w http.ResponseWriter, conte int, object interface{}) error {
+func (h *apiHandler) writeJSON(w http.ResponseWriter, code int, object interface{}) error {
 	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(code)
 	if err := json.NewEncoder(w).Encode(object); err != nil {
 		return wrapper.Wrap(err, "error while write json")
 	}
@@ -141,29 +587,48 @@ func (h *apiHandler) writeJSON(w http.ResponseWriter, conte int, object interfac
 	return nil
 }
 
-func (h *apiHandler) readKeys(private, public string) error {
-	privateBytes, err := ioutil.ReadFile(private)
-	if err != nil {
-		return wrapper.Wrap(err, "error while read private key")
+// readKeys reads the active signing key of the algorithm and keys verifying tokens, public
+// is the comma separated list of files, so keys of tokens signed before the rotation can be
+// kept until these tokens expire. Verify keys are PEM public keys of any supported algorithm,
+// or HMAC secrets when tokens are signed by HS256. The signing key always verifies its tokens
+func (h *apiHandler) readKeys(alg, private, public string) error {
+	if alg == "" {
+		alg = signedMethod
 	}
 
-	publicBytes, err := ioutil.ReadFile(public)
+	privateBytes, err := ioutil.ReadFile(private)
 	if err != nil {
-		return wrapper.Wrap(err, "error while read public key")
+		return wrapper.Wrap(err, "error while read private key")
 	}
 
-	privateKey, err := parsePrivateKey(privateBytes)
+	signKey, signVerifyKey, err := parseSigningKey(alg, privateBytes)
 	if err != nil {
 		return wrapper.Wrap(err, "error while parse private key")
 	}
 
-	publicKey, err := parsePublicKey(publicBytes)
-	if err != nil {
-		return wrapper.Wrap(err, "error while parse public key")
+	verifyKeys := keySet{}
+	verifyKeys.add(signVerifyKey)
+	for _, path := range strings.Split(public, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+
+		publicBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return wrapper.Wrapf(err, "error while read public key %s", path)
+		}
+
+		key, err := parseVerifyKey(publicBytes)
+		if err != nil {
+			return wrapper.Wrapf(err, "error while parse public key %s", path)
+		}
+
+		verifyKeys.add(key)
 	}
 
-	h.signKey = privateKey
-	h.verifyKey = publicKey
+	h.signKey = signKey
+	h.verifyKeys = verifyKeys
+	log.Infof("tokens are signed by %s key %s, %d verify keys", signKey.alg, signKey.id, len(verifyKeys))
 
 	return nil
 }
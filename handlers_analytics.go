@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReportWindow = 24 * time.Hour
+	defaultReportLimit  = 10
+)
+
+// recordSearch queues the search to the analytics sink, returns id of the search
+func (h *apiHandler) recordSearch(r *http.Request, collection string, params queryParams, result searchResult, latency time.Duration) string {
+	event := SearchEvent{
+		ID:          uuid.New().String(),
+		Time:        time.Now().UTC(),
+		Collection:  collection,
+		Query:       normalizeQuery(params.q),
+		Profile:     params.profile,
+		ResultCount: result.total,
+		LatencyMs:   int64(latency / time.Millisecond),
+		Offset:      params.offset,
+		Results:     make([]string, 0, len(result.hits)),
+		Experiment:  params.experiment,
+		Variant:     params.variant,
+	}
+
+	for _, hit := range result.hits {
+		event.Results = append(event.Results, hit.ID)
+	}
+
+	if params.filterField != "" {
+		event.Filter = params.filterField + ":" + params.filterValue
+	}
+	event.Filters = params.filters()
+
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		event.Source = claims.Info.Source
+	}
+
+	if err := h.analytics.recordSearch(event); err != nil {
+		log.Warnf("search %s is not recorded: %v", event.ID, err)
+	}
+
+	return event.ID
+}
+
+// postEventV1 records feedback on the search of the collection, searches
+// of other collections are not found
+func (h *apiHandler) postEventV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	event := FeedbackEvent{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := event.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	search, err := h.analytics.search(event.SearchID)
+	if err == nil && eventCollection(search.Collection) != engine.collection {
+		err = errNotFound
+	}
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "search not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	event.ID = uuid.New().String()
+	event.Time = time.Now().UTC()
+	event.Collection = engine.collection
+	event.Query = search.Query
+	event.Position = search.position(event.ProductID)
+	event.Experiment = search.Experiment
+	event.Variant = search.Variant
+	event.Source = ""
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		event.Source = claims.Info.Source
+	}
+
+	if err := h.analytics.recordFeedback(event); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *apiHandler) getFeedbackReportV1(w http.ResponseWriter, r *http.Request) {
+	from, to, limit, err := reportParams(r)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad query")
+		return
+	}
+
+	// admins bound to the tenant see only reports of its collection
+	claims, _ := claimsFromContext(r.Context())
+	report, err := h.analytics.feedbackReport(claims.Info.Tenant, from, to, limit)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, report); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) getTopQueriesV1(w http.ResponseWriter, r *http.Request) {
+	from, to, limit, err := reportParams(r)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad query")
+		return
+	}
+
+	// admins bound to the tenant see only reports of its collection
+	claims, _ := claimsFromContext(r.Context())
+	stats, err := h.analytics.topQueries(claims.Info.Tenant, from, to, limit)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, stats); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) getZeroResultsV1(w http.ResponseWriter, r *http.Request) {
+	from, to, limit, err := reportParams(r)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad query")
+		return
+	}
+
+	// admins bound to the tenant see only reports of its collection
+	claims, _ := claimsFromContext(r.Context())
+	stats, err := h.analytics.zeroResults(claims.Info.Tenant, from, to, limit)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, stats); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) getExperimentReportV1(w http.ResponseWriter, r *http.Request) {
+	from, to, _, err := reportParams(r)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad query")
+		return
+	}
+
+	// admins bound to the tenant see only reports of its collection
+	claims, _ := claimsFromContext(r.Context())
+	report, err := h.analytics.experimentReport(claims.Info.Tenant, mux.Vars(r)["name"], from, to)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, report); err != nil {
+		log.Error(err)
+	}
+}
+
+// reportParams parses the report time window as RFC3339 from and to,
+// the last day is reported by default
+func reportParams(r *http.Request) (time.Time, time.Time, int, error) {
+	values := r.URL.Query()
+	to := time.Now().UTC()
+	limit := defaultReportLimit
+
+	if value := values.Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultReportWindow)
+	if value := values.Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		from = parsed
+	}
+
+	if value := values.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		if parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	return from, to, limit, nil
+}
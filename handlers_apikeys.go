@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// getAPIKeysV1 lists api keys of the tenant the admin manages
+func (h *apiHandler) getAPIKeysV1(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	all, err := h.elastic.apiKeys()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	keys := []APIKey{}
+	for _, k := range all {
+		if claims.manages(k.Tenant) {
+			k.KeyHash = ""
+			keys = append(keys, k)
+		}
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, keys); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postAPIKeyV1(w http.ResponseWriter, r *http.Request) {
+	k := APIKey{}
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := k.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.grantable(w, r, &k.Tenant, k.Scopes) {
+		return
+	}
+
+	creds, err := h.elastic.createAPIKey(k)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	creds.KeyHash = ""
+	if err := h.writeJSON(w, http.StatusCreated, creds); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) deleteAPIKeyV1(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	k, err := h.elastic.getAPIKey(mux.Vars(r)["id"])
+	if err == nil && !claims.manages(k.Tenant) {
+		err = errNotFound
+	}
+	if err == nil {
+		err = h.elastic.deleteAPIKey(k)
+	}
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "api key not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+func (h *apiHandler) getCategoriesV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	categories, err := engine.categories()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, categoryTree(categories)); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postCategoryV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	c := Category{}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	c, err := newCategory(c.Path)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c, err = engine.putCategory(c)
+	if err == errNoParentCategory {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == errCategoryExists {
+		h.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusCreated, c); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) deleteCategoryV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	path, err := normalizeCategory(mux.Vars(r)["path"])
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = engine.deleteCategory(path)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "category not found")
+		return
+	}
+	if err == errCategoryHasChildren || err == errCategoryInUse {
+		h.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// knownCategories responds with bad request when categories of products are not in the taxonomy of the collection
+func (h *apiHandler) knownCategories(w http.ResponseWriter, engine elasticEngine, docs []Doc) bool {
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		doc.setCategories()
+		if doc.Category != "" && !seen[doc.Category] {
+			seen[doc.Category] = true
+			paths = append(paths, doc.Category)
+		}
+	}
+
+	missing, err := engine.missingCategories(paths)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+
+	if len(missing) > 0 {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown categories: %s", strings.Join(missing, ", ")))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// sampleClientCreds are the credentials of the old app.env sample, they are published
+// and never accepted as the bootstrap client
+const sampleClientCreds = "someid:somesecret"
+
+// authenticate returns the registered client with the credentials, CLIENT_CREDS is
+// the bootstrap admin client which is used to register other clients
+func (h *apiHandler) authenticate(id, secret string) (Client, error) {
+	bootstrap := os.Getenv("CLIENT_CREDS")
+	if bootstrap != "" && bootstrap != sampleClientCreds &&
+		subtle.ConstantTimeCompare([]byte(bootstrap), []byte(id+":"+secret)) == 1 {
+		return Client{ID: id, Enabled: true, Tenant: os.Getenv("CLIENT_TENANT"), Scopes: []string{scopeAdmin}}, nil
+	}
+
+	return h.elastic.authenticateClient(id, secret)
+}
+
+// getClientsV1 lists clients of the tenant the admin manages
+func (h *apiHandler) getClientsV1(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	all, err := h.elastic.clients()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	clients := []Client{}
+	for _, c := range all {
+		if claims.manages(c.Tenant) {
+			c.SecretHash = ""
+			clients = append(clients, c)
+		}
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, clients); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postClientV1(w http.ResponseWriter, r *http.Request) {
+	c := Client{}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := c.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.grantable(w, r, &c.Tenant, c.Scopes) {
+		return
+	}
+
+	creds, err := h.elastic.createClient(c)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	creds.SecretHash = ""
+	if err := h.writeJSON(w, http.StatusCreated, creds); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) putClientV1(w http.ResponseWriter, r *http.Request) {
+	update := ClientUpdate{}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := update.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// omitted tenant is kept, the managed client is of the admin tenant or any tenant for global admins
+	tenant := ""
+	if update.Tenant != nil {
+		tenant = *update.Tenant
+	}
+	if !h.managedClient(w, r, mux.Vars(r)["id"]) || !h.grantable(w, r, &tenant, update.Scopes) {
+		return
+	}
+	if update.Tenant != nil {
+		update.Tenant = &tenant
+	}
+
+	c, err := h.elastic.updateClient(mux.Vars(r)["id"], update)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if !c.Enabled {
+		if err := h.revokeClientTokens(c); err != nil {
+			log.Error(err)
+			h.writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+
+	c.SecretHash = ""
+	if err := h.writeJSON(w, http.StatusOK, c); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postClientSecretV1(w http.ResponseWriter, r *http.Request) {
+	if !h.managedClient(w, r, mux.Vars(r)["id"]) {
+		return
+	}
+
+	creds, err := h.elastic.rotateClientSecret(mux.Vars(r)["id"])
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.revokeClientTokens(creds.Client); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	creds.SecretHash = ""
+	if err := h.writeJSON(w, http.StatusOK, creds); err != nil {
+		log.Error(err)
+	}
+}
+
+// revokeClientTokens revokes tokens of the previous generation of the client
+// until the latest of them expires
+func (h *apiHandler) revokeClientTokens(c Client) error {
+	_, err := h.revocations.revoke(clientGenerationID(c.ID, c.Generation-1), time.Now().Add(h.tokensLifetime()))
+	return err
+}
+
+// managedClient responds with not found when the client doesn't exist or
+// belongs to the tenant the admin doesn't manage
+func (h *apiHandler) managedClient(w http.ResponseWriter, r *http.Request, id string) bool {
+	claims, _ := claimsFromContext(r.Context())
+	c, err := h.elastic.getClient(id)
+	if err == errNotFound || (err == nil && !claims.manages(c.Tenant)) {
+		h.writeError(w, http.StatusNotFound, "client not found")
+		return false
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+
+	return true
+}
+
+// grantable responds with forbidden when the admin can't grant the tenant and scopes,
+// empty tenant is set to the tenant of the admin
+func (h *apiHandler) grantable(w http.ResponseWriter, r *http.Request, tenant *string, scopes []string) bool {
+	claims, _ := claimsFromContext(r.Context())
+	if *tenant == "" {
+		*tenant = claims.Info.Tenant
+	}
+
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+
+	if err := claims.canGrant(*tenant, scopes); err != nil {
+		log.Info(err)
+		h.writeError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// engine returns the elastic engine of the requested collection. Collection is
+// taken from the path, legacy routes without it use the token tenant and the default
+// collection for tokens without tenant. Tokens with tenant can't access other collections
+func (h *apiHandler) engine(w http.ResponseWriter, r *http.Request) (elasticEngine, bool) {
+	claims, _ := claimsFromContext(r.Context())
+	tenant := claims.Info.Tenant
+
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		name = tenant
+	}
+
+	if tenant != "" && tenant != name {
+		h.writeError(w, http.StatusForbidden, "access to collection denied")
+		return elasticEngine{}, false
+	}
+
+	if name == "" || name == defaultCollectionName {
+		return h.elastic, true
+	}
+
+	c, err := h.elastic.getCollection(name)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "collection not found")
+		return elasticEngine{}, false
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return elasticEngine{}, false
+	}
+
+	return h.elastic.forCollection(c), true
+}
+
+// getCollectionsV1 lists collections, admins bound to the tenant see only its collection
+func (h *apiHandler) getCollectionsV1(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	all, err := h.elastic.collections()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	collections := []Collection{}
+	for _, c := range all {
+		if claims.manages(c.Name) {
+			collections = append(collections, c)
+		}
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, collections); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postCollectionV1(w http.ResponseWriter, r *http.Request) {
+	if !h.globalAdmin(w, r) {
+		return
+	}
+
+	c := Collection{}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := c.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c, err := h.elastic.createCollection(c.Name)
+	if err == errCollectionExists {
+		h.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusCreated, c); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) deleteCollectionV1(w http.ResponseWriter, r *http.Request) {
+	if !h.globalAdmin(w, r) {
+		return
+	}
+
+	err := h.elastic.deleteCollection(mux.Vars(r)["name"])
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "collection not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// globalAdmin responds with forbidden to admins bound to the tenant,
+// collections are created and deleted only by admins without tenant
+func (h *apiHandler) globalAdmin(w http.ResponseWriter, r *http.Request) bool {
+	claims, _ := claimsFromContext(r.Context())
+	if claims.Info.Tenant != "" {
+		h.writeError(w, http.StatusForbidden, "collections are managed by admins without tenant")
+		return false
+	}
+
+	return true
+}
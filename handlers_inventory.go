@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stockUpdatesMaxCount limits the size of one stock update request
+const stockUpdatesMaxCount = 1000
+
+func (h *apiHandler) postStockV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	updates := []StockUpdate{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if len(updates) == 0 || len(updates) > stockUpdatesMaxCount {
+		h.writeError(w, http.StatusBadRequest, "updates count should be from 1 to 1000")
+		return
+	}
+
+	for _, update := range updates {
+		if err := update.validate(); err != nil {
+			log.Error(err)
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	result, err := engine.updateStock(updates)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, result); err != nil {
+		log.Error(err)
+	}
+}
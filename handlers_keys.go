@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getJWKS publishes public keys verifying our tokens, so other services can verify them
+func (h *apiHandler) getJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := h.writeJSON(w, http.StatusOK, h.verifyKeys.jwks()); err != nil {
+		log.Error(err)
+	}
+}
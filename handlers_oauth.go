@@ -0,0 +1,277 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+func (h *apiHandler) postOAuthTokenV1(w http.ResponseWriter, r *http.Request) {
+	// token responses should not be cached by clients and proxies
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		log.Error(err)
+		h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "bad form")
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if grantType != grantClientCredentials && grantType != grantRefreshToken {
+		h.writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "only client_credentials and refresh_token grants are supported")
+		return
+	}
+
+	client, ok := h.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	allowed, err := client.grantedScopes("")
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	family := ""
+	if grantType == grantRefreshToken {
+		refresh, err := h.useRefreshToken(r.PostForm.Get("refresh_token"), client)
+		if err == errInvalidGrant {
+			h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			h.writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		// the refreshed token can't have scopes which were removed from the client
+		family = refresh.Family
+		allowed = intersectScopes(refresh.Info.Scopes, allowed)
+	}
+
+	scopes, err := requestScopes(allowed, r.PostForm.Get("scope"))
+	if err != nil {
+		h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, err.Error())
+		return
+	}
+
+	token, err := h.issueOAuthToken(client, scopes, family)
+	if err != nil {
+		log.Errorf("error while make new token: %v", err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, token); err != nil {
+		log.Error(err)
+	}
+}
+
+// postOAuthRevokeV1 revokes the access or refresh token of the client as in RFC 7009,
+// the refresh token is revoked with all tokens of its rotation family
+func (h *apiHandler) postOAuthRevokeV1(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Error(err)
+		h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "bad form")
+		return
+	}
+
+	client, ok := h.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := h.verifyKeys.verify(r.PostForm.Get("token"))
+	if err != nil || claims.StandardClaims == nil || claims.Id == "" {
+		// invalid and expired tokens need no revocation, see RFC 7009 section 2.2
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if claims.Info.Source != client.ID {
+		h.writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "token was issued to another client")
+		return
+	}
+
+	if _, err := h.revocations.revoke(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if claims.TokenType == refreshTokenType {
+		if err := h.revokeRefreshFamily(claims.Family); err != nil {
+			log.Error(err)
+			h.writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+
+	log.Infof("token %s of client %s is revoked", claims.Id, client.ID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// postOAuthIntrospectV1 tells whether the token is active and describes it as in RFC 7662,
+// it's allowed only for clients with the introspect scope
+func (h *apiHandler) postOAuthIntrospectV1(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		log.Error(err)
+		h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "bad form")
+		return
+	}
+
+	client, ok := h.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	scopes, err := client.grantedScopes("")
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if !containsScope(scopes, scopeIntrospect) && !containsScope(scopes, scopeAdmin) {
+		log.Infof("client %s has no scope %s", client.ID, scopeIntrospect)
+		h.writeOAuthError(w, http.StatusForbidden, oauthUnauthorizedClient, "client has no scope "+scopeIntrospect)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "token is missing")
+		return
+	}
+
+	result := TokenIntrospection{}
+	if claims, err := h.activeToken(token); err == nil {
+		result = introspection(claims)
+	} else {
+		log.Infof("introspected token of client %s is inactive: %v", client.ID, err)
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, result); err != nil {
+		log.Error(err)
+	}
+}
+
+// oauthClient authenticates the client of the token endpoint request
+func (h *apiHandler) oauthClient(w http.ResponseWriter, r *http.Request) (Client, bool) {
+	id, secret, err := oauthClientCredentials(r)
+	if err != nil {
+		h.writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return Client{}, false
+	}
+
+	client, err := h.authenticate(id, secret)
+	if err == errBadCredentials {
+		log.Infof("can't authenticate oauth client %s: bad creds", id)
+		w.Header().Set("WWW-Authenticate", `Basic realm="searchapi"`)
+		h.writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+		return Client{}, false
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return Client{}, false
+	}
+
+	return client, true
+}
+
+// issueOAuthToken makes the access token and the refresh token of the rotation family,
+// empty family starts the new one. Both tokens carry the family, so revocation
+// of the family revokes access tokens too
+func (h *apiHandler) issueOAuthToken(client Client, scopes []string, family string) (OAuthToken, error) {
+	if family == "" {
+		family = uuid.New().String()
+	}
+
+	info := tokenInfo{Source: client.ID, Tenant: client.Tenant, Scopes: scopes, Generation: client.Generation}
+	access := newTokenClaims(tokenType, info, time.Now().Add(time.Duration(h.tokenExpireAt)*time.Second).Unix())
+	access.Family = family
+
+	token, err := signToken(h.signKey, access)
+	if err != nil {
+		return OAuthToken{}, err
+	}
+
+	claims := newTokenClaims(refreshTokenType, info, time.Now().Add(time.Duration(h.refreshExpireAt)*time.Second).Unix())
+	claims.Family = family
+
+	refresh, err := signToken(h.signKey, claims)
+	if err != nil {
+		return OAuthToken{}, err
+	}
+
+	return OAuthToken{
+		AccessToken:  token,
+		TokenType:    bearerTokenType,
+		ExpiresIn:    h.tokenExpireAt,
+		Scope:        strings.Join(scopes, " "),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// useRefreshToken checks the refresh token of the client and revokes it, so each refresh
+// token is used once. Reuse of the revoked token means it was leaked, so the whole rotation
+// family is revoked and the client has to authenticate with credentials again
+func (h *apiHandler) useRefreshToken(token string, client Client) (tokenClaims, error) {
+	claims, err := h.verifyKeys.verify(token)
+	if err != nil || claims.TokenType != refreshTokenType || claims.StandardClaims == nil || claims.Id == "" {
+		return tokenClaims{}, errInvalidGrant
+	}
+
+	if claims.Info.Source != client.ID {
+		return tokenClaims{}, errInvalidGrant
+	}
+
+	for _, id := range []string{claims.Family, clientGenerationID(client.ID, claims.Info.Generation)} {
+		revoked, err := h.revocations.revoked(id)
+		if err != nil {
+			return tokenClaims{}, err
+		}
+
+		if revoked {
+			return tokenClaims{}, errInvalidGrant
+		}
+	}
+
+	first, err := h.revocations.revoke(claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return tokenClaims{}, err
+	}
+
+	if !first {
+		log.Warnf("refresh token %s of client %s is reused, revoke family %s", claims.Id, client.ID, claims.Family)
+		if err := h.revokeRefreshFamily(claims.Family); err != nil {
+			return tokenClaims{}, err
+		}
+
+		return tokenClaims{}, errInvalidGrant
+	}
+
+	return claims, nil
+}
+
+// revokeRefreshFamily revokes access and refresh tokens of the family until the latest of them expires
+func (h *apiHandler) revokeRefreshFamily(family string) error {
+	_, err := h.revocations.revoke(family, time.Now().Add(h.tokensLifetime()))
+	return err
+}
+
+func (h *apiHandler) writeOAuthError(w http.ResponseWriter, code int, oauthError, description string) {
+	if err := h.writeJSON(w, code, OAuthError{Error: oauthError, ErrorDescription: description}); err != nil {
+		log.Error(err)
+	}
+}
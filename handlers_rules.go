@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+func (h *apiHandler) getRulesV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := engine.rules()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, rules); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postRuleV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	rule := Rule{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	rule.ID = ""
+	h.saveRule(w, engine, rule, http.StatusCreated)
+}
+
+func (h *apiHandler) putRuleV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	rule := Rule{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	rule.ID = mux.Vars(r)["id"]
+	h.saveRule(w, engine, rule, http.StatusOK)
+}
+
+func (h *apiHandler) saveRule(w http.ResponseWriter, engine elasticEngine, rule Rule, code int) {
+	if err := rule.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule, err := engine.putRule(rule)
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, code, rule); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) deleteRuleV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	err := engine.deleteRule(mux.Vars(r)["id"])
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// savedSearchOwner returns the owner of saved searches, it's the client of the token,
+// so clients see and delete only their own saved searches
+func savedSearchOwner(r *http.Request) string {
+	claims, _ := claimsFromContext(r.Context())
+	return claims.Info.Source
+}
+
+func (h *apiHandler) getSavedSearchesV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	searches, err := engine.savedSearches(savedSearchOwner(r))
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, searches); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postSavedSearchV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	search := SavedSearch{}
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	search.Owner = savedSearchOwner(r)
+	if err := search.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	search, err := engine.putSavedSearch(search)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusCreated, search); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) deleteSavedSearchV1(w http.ResponseWriter, r *http.Request) {
+	engine, ok := h.engine(w, r)
+	if !ok {
+		return
+	}
+
+	err := engine.deleteSavedSearch(mux.Vars(r)["id"], savedSearchOwner(r))
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "saved search not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
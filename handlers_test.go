@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/joho/godotenv"
@@ -12,8 +13,10 @@ import (
 )
 
 func TestGetTokenV1(t *testing.T) {
+	skipIntegration(t)
+
 	Convey("Test get token", t, func() {
-		handlers, err := newAPIHandler(testElasticAddr, tokenSignKey, tokenVerifyKey, tokenExpireAt)
+		handlers, err := newAPIHandler(apiConfig{ElasticAddr: testElasticAddr, SignKey: tokenSignKey, VerifyKeys: tokenVerifyKey, TokenExpireAt: tokenExpireAt})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -22,7 +25,11 @@ func TestGetTokenV1(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		req, err := http.NewRequest("GET", "/v1/auth?creds=someid:somesecret", nil)
+		creds := os.Getenv("CLIENT_CREDS")
+		os.Setenv("CLIENT_CREDS", "bootstrap:secret")
+		defer os.Setenv("CLIENT_CREDS", creds)
+
+		req, err := http.NewRequest("GET", "/v1/auth?creds=bootstrap:secret", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -47,8 +54,10 @@ func TestGetTokenV1(t *testing.T) {
 }
 
 func TestPostProductV1(t *testing.T) {
+	skipIntegration(t)
+
 	Convey("Test post product", t, func() {
-		handlers, err := newAPIHandler(testElasticAddr, tokenSignKey, tokenVerifyKey, tokenExpireAt)
+		handlers, err := newAPIHandler(apiConfig{ElasticAddr: testElasticAddr, SignKey: tokenSignKey, VerifyKeys: tokenVerifyKey, TokenExpireAt: tokenExpireAt})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -71,7 +80,7 @@ func TestPostProductV1(t *testing.T) {
 		}
 
 		rr := httptest.NewRecorder()
-		handlers, err = newAPIHandler(testElasticAddr, tokenSignKey, tokenVerifyKey, tokenExpireAt)
+		handlers, err = newAPIHandler(apiConfig{ElasticAddr: testElasticAddr, SignKey: tokenSignKey, VerifyKeys: tokenVerifyKey, TokenExpireAt: tokenExpireAt})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -82,7 +91,7 @@ func TestPostProductV1(t *testing.T) {
 				status, http.StatusOK)
 		}
 
-		shoes, err := handlers.elastic.get(queryParams{
+		result, err := handlers.elastic.get(queryParams{
 			q:      testDoc.Name,
 			limit:  1,
 			offset: 0,
@@ -91,13 +100,15 @@ func TestPostProductV1(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		So(shoes[0].Name, ShouldEqual, testDoc.Name)
+		So(result.hits[0].Name, ShouldEqual, testDoc.Name)
 	})
 }
 
 func TestGetProductsV1(t *testing.T) {
+	skipIntegration(t)
+
 	Convey("Test get products", t, func() {
-		handlers, err := newAPIHandler(testElasticAddr, tokenSignKey, tokenVerifyKey, tokenExpireAt)
+		handlers, err := newAPIHandler(apiConfig{ElasticAddr: testElasticAddr, SignKey: tokenSignKey, VerifyKeys: tokenVerifyKey, TokenExpireAt: tokenExpireAt})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -125,7 +136,7 @@ func TestGetProductsV1(t *testing.T) {
 			},
 		}
 
-		if err := handlers.elastic.put(testDocs); err != nil {
+		if _, err := handlers.elastic.put(testDocs); err != nil {
 			t.Fatal(err)
 		}
 
@@ -153,4 +164,5 @@ func TestGetProductsV1(t *testing.T) {
 
 func TestMain(m *testing.M) {
 	godotenv.Load(envFile)
+	os.Exit(m.Run())
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultDeadLettersLimit = 100
+
+// getWebhooksV1 lists webhooks of the collections the admin manages
+func (h *apiHandler) getWebhooksV1(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	all, err := h.elastic.webhooks()
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	subscriptions := []WebhookSubscription{}
+	for _, subscription := range all {
+		if claims.manages(subscription.Collection) {
+			// secret is shown only once on create
+			subscription.Secret = ""
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, subscriptions); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) postWebhookV1(w http.ResponseWriter, r *http.Request) {
+	subscription := WebhookSubscription{}
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, "bad input JSON")
+		return
+	}
+
+	if err := subscription.validate(); err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// admins bound to the tenant subscribe only to events of its collection
+	claims, _ := claimsFromContext(r.Context())
+	if subscription.Collection == "" {
+		subscription.Collection = claims.Info.Tenant
+	}
+	if !claims.manages(subscription.Collection) {
+		h.writeError(w, http.StatusForbidden, "webhook of another collection")
+		return
+	}
+
+	subscription, err := h.elastic.putWebhook(subscription)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusCreated, subscription); err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *apiHandler) deleteWebhookV1(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	subscription, err := h.elastic.getWebhook(mux.Vars(r)["id"])
+	if err == nil && !claims.manages(subscription.Collection) {
+		err = errNotFound
+	}
+	if err == nil {
+		err = h.elastic.deleteWebhook(subscription.ID)
+	}
+	if err == errNotFound {
+		h.writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *apiHandler) getDeadLettersV1(w http.ResponseWriter, r *http.Request) {
+	limit := defaultDeadLettersLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			h.writeError(w, http.StatusBadRequest, "bad limit")
+			return
+		}
+		limit = parsed
+	}
+
+	// admins bound to the tenant see only dead letters of its collection
+	claims, _ := claimsFromContext(r.Context())
+	letters, err := h.elastic.deadLetters(r.URL.Query().Get("subscription_id"), claims.Info.Tenant, limit)
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.writeJSON(w, http.StatusOK, letters); err != nil {
+		log.Error(err)
+	}
+}
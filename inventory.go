@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	availabilityInStock    = "in_stock"
+	availabilityOutOfStock = "out_of_stock"
+	availabilityPreorder   = "preorder"
+
+	storesField = "stores"
+
+	// stockRetryOnConflict retries concurrent stock updates of the same product
+	stockRetryOnConflict = 3
+
+	// storeStockScript sets the stock of the store, the store is added when the product has no it
+	storeStockScript = `
+if (ctx._source.stores == null) {
+	ctx._source.stores = [];
+}
+boolean found = false;
+for (store in ctx._source.stores) {
+	if (store.store_id == params.store_id) {
+		store.stock = params.stock;
+		store.availability = params.availability;
+		found = true;
+	}
+}
+if (!found) {
+	ctx._source.stores.add(['store_id': params.store_id, 'stock': params.stock, 'availability': params.availability]);
+}
+`
+	productStockScript = `
+ctx._source.stock = params.stock;
+ctx._source.availability = params.availability;
+`
+)
+
+// StockUpdate sets the stock of the product or of the product in the store,
+// empty availability is set from the stock
+type StockUpdate struct {
+	ID           string `json:"id"`
+	StoreID      string `json:"store_id,omitempty"`
+	Stock        int    `json:"stock"`
+	Availability string `json:"availability,omitempty"`
+}
+
+// StockUpdateResult lists updates which were not applied
+type StockUpdateResult struct {
+	Updated int           `json:"updated"`
+	Failed  []StockFailed `json:"failed"`
+}
+
+// StockFailed is the update of the product which was not applied
+type StockFailed struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// availabilityOf returns the status of the stock quantity
+func availabilityOf(stock int) string {
+	if stock > 0 {
+		return availabilityInStock
+	}
+
+	return availabilityOutOfStock
+}
+
+func validateAvailability(availability string) error {
+	switch availability {
+	case "", availabilityInStock, availabilityOutOfStock, availabilityPreorder:
+		return nil
+	default:
+		return fmt.Errorf("unknown availability %q", availability)
+	}
+}
+
+func (su *StockUpdate) validate() error {
+	if su.ID == "" {
+		return errors.New("id is empty")
+	}
+
+	if su.Stock < 0 {
+		return errors.New("stock should not be negative")
+	}
+
+	return validateAvailability(su.Availability)
+}
+
+// validateStock checks the product and store stock, the stock is nil when it isn't tracked
+func (d *Doc) validateStock() error {
+	if d.Stock != nil && *d.Stock < 0 {
+		return errors.New("stock should not be negative")
+	}
+
+	if err := validateAvailability(d.Availability); err != nil {
+		return err
+	}
+
+	stores := map[string]bool{}
+	for _, store := range d.Stores {
+		if store.StoreID == "" || stores[store.StoreID] {
+			return errors.New("store_id is empty or not unique")
+		}
+		stores[store.StoreID] = true
+
+		if store.Stock < 0 {
+			return fmt.Errorf("stock of store %q should not be negative", store.StoreID)
+		}
+
+		if err := validateAvailability(store.Availability); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setAvailability sets empty availability of the product and stores from the stock,
+// the product without own stock is available when any of its variants is in stock
+func (d *Doc) setAvailability() {
+	if d.Availability == "" && d.Stock != nil {
+		d.Availability = availabilityOf(*d.Stock)
+	}
+
+	if d.Availability == "" && len(d.Variants) > 0 {
+		stock := 0
+		for _, variant := range d.Variants {
+			stock += variant.Stock
+		}
+		d.Availability = availabilityOf(stock)
+	}
+
+	for i := range d.Stores {
+		if d.Stores[i].Availability == "" {
+			d.Stores[i].Availability = availabilityOf(d.Stores[i].Stock)
+		}
+	}
+}
+
+// storesQuery matches the store the product is found by: the store of the query, in stock
+// with in_stock and within the radius, all conditions apply to the same store.
+// False means the product is found by its own availability and any of its locations
+func storesQuery(params queryParams) (*elastic.BoolQuery, bool) {
+	inStock := params.inStock != nil && *params.inStock
+	radius := params.geo != nil && params.radius != ""
+	if params.store == "" && !(inStock && radius) {
+		return nil, false
+	}
+
+	query := elastic.NewBoolQuery()
+	if params.store != "" {
+		query.Filter(elastic.NewTermQuery(storesField+".store_id", params.store))
+	}
+
+	if params.inStock != nil {
+		available := elastic.NewTermQuery(storesField+".availability", availabilityInStock)
+		if *params.inStock {
+			query.Filter(available)
+		} else {
+			query.MustNot(available)
+		}
+	}
+
+	if radius {
+		query.Filter(elastic.NewGeoDistanceQuery(storesField+".location").Point(params.geo.Lat, params.geo.Lon).Distance(params.radius))
+	}
+
+	return query, true
+}
+
+// addStockFilter narrows the query by availability of the product or of the product
+// in the store, products which stock isn't tracked are considered available.
+// The store within the radius is filtered by addGeoFilter
+func addStockFilter(q elastic.Query, params queryParams) elastic.Query {
+	if params.inStock == nil && params.store == "" {
+		return q
+	}
+
+	query := elastic.NewBoolQuery().Must(q)
+	if stores, ok := storesQuery(params); ok {
+		if params.geo != nil && params.radius != "" {
+			return q
+		}
+
+		return query.Filter(elastic.NewNestedQuery(storesField, stores))
+	}
+
+	unavailable := elastic.NewTermsQuery("availability", availabilityOutOfStock, availabilityPreorder)
+	if *params.inStock {
+		return query.MustNot(unavailable)
+	}
+
+	return query.Filter(unavailable)
+}
+
+// updateStock applies stock updates in one bulk request without reading products back,
+// it's cheaper than the product update, so catalog change hooks get only ids of updated products
+func (ee *elasticEngine) updateStock(updates []StockUpdate) (StockUpdateResult, error) {
+	bulk := ee.client.Bulk().Index(ee.index)
+	for _, update := range updates {
+		availability := update.Availability
+		if availability == "" {
+			availability = availabilityOf(update.Stock)
+		}
+
+		script := elastic.NewScript(productStockScript)
+		params := map[string]interface{}{"stock": update.Stock, "availability": availability}
+		if update.StoreID != "" {
+			script = elastic.NewScript(storeStockScript)
+			params["store_id"] = update.StoreID
+		}
+
+		bulk.Add(elastic.NewBulkUpdateRequest().
+			Id(update.ID).
+			Script(script.Params(params)).
+			RetryOnConflict(stockRetryOnConflict))
+	}
+
+	resp, err := bulk.Do(context.Background())
+	if err != nil {
+		return StockUpdateResult{}, wrapper.Wrap(err, "error while update stock")
+	}
+
+	result := StockUpdateResult{Failed: []StockFailed{}}
+	for _, item := range resp.Failed() {
+		reason := "failed"
+		if item.Error != nil {
+			reason = item.Error.Reason
+		}
+		result.Failed = append(result.Failed, StockFailed{ID: item.Id, Error: reason})
+	}
+	result.Updated = len(updates) - len(result.Failed)
+
+	if updated := stockUpdatedDocs(resp.Succeeded()); len(updated) > 0 {
+		ee.runHooks(docUpdated, updated)
+	}
+
+	return result, nil
+}
+
+// stockUpdatedDocs returns products of applied stock updates with only ids,
+// the product updated in several stores is returned once
+func stockUpdatedDocs(items []*elastic.BulkResponseItem) []Doc {
+	docs := []Doc{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		if !seen[item.Id] {
+			seen[item.Id] = true
+			docs = append(docs, Doc{ID: item.Id})
+		}
+	}
+
+	return docs
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAvailability(t *testing.T) {
+	Convey("Check availability is set from stock", t, func() {
+		inStock, outOfStock := 3, 0
+
+		doc := Doc{Stock: &inStock, Stores: []StoreStock{{StoreID: "berlin-1"}, {StoreID: "paris-1", Stock: 1}}}
+		So(doc.validate(), ShouldBeNil)
+		doc.setAvailability()
+		So(doc.Availability, ShouldEqual, availabilityInStock)
+		So(doc.Stores[0].Availability, ShouldEqual, availabilityOutOfStock)
+		So(doc.Stores[1].Availability, ShouldEqual, availabilityInStock)
+
+		doc = Doc{Stock: &outOfStock, Availability: availabilityPreorder}
+		doc.setAvailability()
+		So(doc.Availability, ShouldEqual, availabilityPreorder)
+
+		doc = Doc{}
+		doc.setAvailability()
+		So(doc.Availability, ShouldBeEmpty)
+
+		doc = Doc{Variants: []Variant{{SKU: "a"}, {SKU: "b"}}}
+		doc.setAvailability()
+		So(doc.Availability, ShouldEqual, availabilityOutOfStock)
+
+		doc = Doc{Variants: []Variant{{SKU: "a"}, {SKU: "b", Stock: 2}}}
+		doc.setAvailability()
+		So(doc.Availability, ShouldEqual, availabilityInStock)
+
+		So((&Doc{Availability: "soon"}).validate(), ShouldNotBeNil)
+		So((&Doc{Stores: []StoreStock{{StoreID: "a"}, {StoreID: "a"}}}).validate(), ShouldNotBeNil)
+
+		So((&StockUpdate{ID: "1", Stock: 5}).validate(), ShouldBeNil)
+		So((&StockUpdate{Stock: 5}).validate(), ShouldNotBeNil)
+		So((&StockUpdate{ID: "1", Stock: -5}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestStockFilter(t *testing.T) {
+	Convey("Check in_stock filter of product and store", t, func() {
+		params := queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?in_stock=true", nil)), ShouldBeNil)
+		So(*params.inStock, ShouldBeTrue)
+
+		body := querySource(t, addStockFilter(elastic.NewMatchAllQuery(), params))
+		So(body, ShouldContainSubstring, `"must_not":{"terms":{"availability":["out_of_stock","preorder"]}}`)
+
+		params = queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?in_stock=true&store=berlin-1", nil)), ShouldBeNil)
+
+		body = querySource(t, addStockFilter(elastic.NewMatchAllQuery(), params))
+		So(body, ShouldContainSubstring, `"nested":{"path":"stores"`)
+		So(body, ShouldContainSubstring, `{"term":{"stores.store_id":"berlin-1"}},{"term":{"stores.availability":"in_stock"}}`)
+
+		So((&queryParams{}).fill(httptest.NewRequest("GET", "/v1/products?in_stock=maybe", nil)), ShouldNotBeNil)
+	})
+}
+
+func querySource(t *testing.T, q elastic.Query) string {
+	source, err := q.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestStockUpdatedDocs(t *testing.T) {
+	Convey("Check stock updates notify about updated products once", t, func() {
+		docs := stockUpdatedDocs([]*elastic.BulkResponseItem{{Id: "1"}, {Id: "2"}, {Id: "1"}})
+		So(docs, ShouldResemble, []Doc{{ID: "1"}, {ID: "2"}})
+
+		So(stockUpdatedDocs(nil), ShouldBeEmpty)
+	})
+}
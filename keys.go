@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	signedMethodES256 = "ES256"
+	signedMethodEdDSA = "EdDSA"
+	signedMethodHS256 = "HS256"
+
+	// hmacMinSecretLen is the length of the SHA-256 output, RFC 7518 section 3.2
+	hmacMinSecretLen = 32
+
+	keyIDHeader = "kid"
+	signKeyUse  = "sig"
+)
+
+// JSONWebKey is the public key of RFC 7517, members depend on the key type
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet publishes keys verifying our tokens
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// signingKey is the active key signing new tokens
+type signingKey struct {
+	alg string
+	id  string
+	key interface{}
+}
+
+// verifyKey is the public key, or the secret for HMAC, with the only algorithm it verifies
+type verifyKey struct {
+	alg string
+	key interface{}
+}
+
+// keySet holds keys verifying tokens by the kid header. Key ids are RFC 7638
+// thumbprints, so they are the same on every instance and don't need to be configured
+type keySet map[string]verifyKey
+
+func rsaSigningKey(key *rsa.PrivateKey) signingKey {
+	return signingKey{alg: signedMethod, id: keyID(&key.PublicKey), key: key}
+}
+
+// parseSigningKey parses the private key of the algorithm, it's PEM for asymmetric
+// algorithms and the raw secret for HMAC. The key verifying its tokens is returned too
+func parseSigningKey(alg string, data []byte) (signingKey, verifyKey, error) {
+	switch alg {
+	case signedMethod:
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return signingKey{}, verifyKey{}, err
+		}
+
+		return rsaSigningKey(key), verifyKey{alg: alg, key: &key.PublicKey}, nil
+
+	case signedMethodES256:
+		key, err := jwt.ParseECPrivateKeyFromPEM(data)
+		if err != nil {
+			return signingKey{}, verifyKey{}, err
+		}
+
+		if key.Curve != elliptic.P256() {
+			return signingKey{}, verifyKey{}, errors.New("ES256 key should use P-256 curve")
+		}
+
+		return signingKey{alg: alg, id: keyID(&key.PublicKey), key: key}, verifyKey{alg: alg, key: &key.PublicKey}, nil
+
+	case signedMethodEdDSA:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return signingKey{}, verifyKey{}, errors.New("key should be PEM encoded")
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return signingKey{}, verifyKey{}, err
+		}
+
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return signingKey{}, verifyKey{}, errors.New("EdDSA key should be Ed25519 key")
+		}
+
+		public := key.Public().(ed25519.PublicKey)
+		return signingKey{alg: alg, id: keyID(public), key: key}, verifyKey{alg: alg, key: public}, nil
+
+	case signedMethodHS256:
+		secret, err := parseSecret(data)
+		if err != nil {
+			return signingKey{}, verifyKey{}, err
+		}
+
+		return signingKey{alg: alg, id: keyID(secret), key: secret}, verifyKey{alg: alg, key: secret}, nil
+
+	default:
+		return signingKey{}, verifyKey{}, fmt.Errorf("unknown signing algorithm %q", alg)
+	}
+}
+
+// parseVerifyKey parses the key of one file, the algorithm is chosen by the file, not by
+// the signing algorithm, so keys of the previous algorithm verify old tokens after the
+// algorithm change. PEM public keys verify tokens of the key type, anything else is the
+// HMAC secret pinned to HS256. Broken PEM is an error, so a public key which is known
+// to everybody never becomes the secret
+func parseVerifyKey(data []byte) (verifyKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			return verifyKey{}, errors.New("key should be PEM encoded")
+		}
+
+		secret, err := parseSecret(data)
+		if err != nil {
+			return verifyKey{}, err
+		}
+
+		return verifyKey{alg: signedMethodHS256, key: secret}, nil
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		parsed = key
+
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		parsed = cert.PublicKey
+
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		parsed = key
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return verifyKey{alg: signedMethod, key: key}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return verifyKey{}, errors.New("ES256 key should use P-256 curve")
+		}
+		return verifyKey{alg: signedMethodES256, key: key}, nil
+	case ed25519.PublicKey:
+		return verifyKey{alg: signedMethodEdDSA, key: key}, nil
+	default:
+		return verifyKey{}, fmt.Errorf("unsupported public key type %T", parsed)
+	}
+}
+
+// parseSecret trims the trailing new line of the secret file
+func parseSecret(data []byte) ([]byte, error) {
+	secret := bytes.TrimRight(data, "\r\n")
+	if len(secret) < hmacMinSecretLen {
+		return nil, fmt.Errorf("HMAC secret should have at least %d bytes", hmacMinSecretLen)
+	}
+
+	return secret, nil
+}
+
+func base64URLInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// base64URLCoordinate encodes the EC point coordinate padded to the curve size, RFC 7518 section 6.2.1.2
+func base64URLCoordinate(i *big.Int, curve elliptic.Curve) string {
+	size := (curve.Params().BitSize + 7) / 8
+	coordinate := make([]byte, size)
+	b := i.Bytes()
+	copy(coordinate[size-len(b):], b)
+	return base64.RawURLEncoding.EncodeToString(coordinate)
+}
+
+// jsonWebKey returns the public key of the set, HMAC secrets are never published
+func (vk verifyKey) jsonWebKey(kid string) (JSONWebKey, bool) {
+	jwk := JSONWebKey{Use: signKeyUse, Alg: vk.alg, Kid: kid}
+	switch key := vk.key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64URLInt(key.N)
+		jwk.E = base64URLInt(big.NewInt(int64(key.E)))
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64URLCoordinate(key.X, key.Curve)
+		jwk.Y = base64URLCoordinate(key.Y, key.Curve)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JSONWebKey{}, false
+	}
+
+	return jwk, true
+}
+
+// keyID returns the RFC 7638 thumbprint of the key
+func keyID(key interface{}) string {
+	// members are in lexicographic order without whitespace as the RFC requires
+	var thumbprint string
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		thumbprint = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			base64URLInt(big.NewInt(int64(key.E))), base64URLInt(key.N))
+	case *ecdsa.PublicKey:
+		thumbprint = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			key.Curve.Params().Name, base64URLCoordinate(key.X, key.Curve), base64URLCoordinate(key.Y, key.Curve))
+	case ed25519.PublicKey:
+		thumbprint = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(key))
+	case []byte:
+		thumbprint = fmt.Sprintf(`{"k":"%s","kty":"oct"}`, base64.RawURLEncoding.EncodeToString(key))
+	}
+
+	hash := sha256.Sum256([]byte(thumbprint))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// add adds the key by its id
+func (ks keySet) add(key verifyKey) {
+	ks[keyID(key.key)] = key
+}
+
+// keyFunc chooses the key by the kid header and pins its algorithm, so the token can't
+// choose how it's verified, like HS256 with the public RSA key as the secret. Tokens
+// issued before key ids were added have no kid and are accepted only while there is one key
+func (ks keySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header[keyIDHeader].(string)
+
+	var key verifyKey
+	if kid == "" {
+		if len(ks) != 1 {
+			return nil, errors.New("token has no key id")
+		}
+
+		for _, k := range ks {
+			key = k
+		}
+	} else {
+		k, ok := ks[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		key = k
+	}
+
+	if t.Method == nil || t.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("token signing method %v, expect %s", t.Header["alg"], key.alg)
+	}
+
+	return key.key, nil
+}
+
+// verify parses token claims and checks the token is signed by one of the keys
+func (ks keySet) verify(token string) (tokenClaims, error) {
+	return parseToken(token, ks.keyFunc)
+}
+
+// jwks returns public keys ordered by id
+func (ks keySet) jwks() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for kid, key := range ks {
+		if jwk, ok := key.jsonWebKey(kid); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyID(t *testing.T) {
+	Convey("Check key id is the RFC 7638 thumbprint", t, func() {
+		// the example key of RFC 7638 section 3.1
+		n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+		So(err, ShouldBeNil)
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+		So(keyID(key), ShouldEqual, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs")
+
+		jwk, ok := verifyKey{alg: signedMethod, key: key}.jsonWebKey(keyID(key))
+		So(ok, ShouldBeTrue)
+		So(jwk.E, ShouldEqual, "AQAB")
+		So(jwk.Kty, ShouldEqual, "RSA")
+	})
+}
+
+func TestKeySetVerify(t *testing.T) {
+	Convey("Check tokens are verified by the key with kid of the token", t, func() {
+		oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+
+		token, err := signToken(rsaSigningKey(getSignKey(t)), newTokenClaims(tokenType, tokenInfo{Source: "000000000"}, time.Now().Add(time.Minute).Unix()))
+		So(err, ShouldBeNil)
+
+		ks := keySet{}
+		ks.add(verifyKey{alg: signedMethod, key: &oldKey.PublicKey})
+		_, err = ks.verify(token)
+		So(err, ShouldNotBeNil)
+
+		ks.add(verifyKey{alg: signedMethod, key: getVerifyKey(t)})
+		claims, err := ks.verify(token)
+		So(err, ShouldBeNil)
+		So(claims.Info.Source, ShouldEqual, "000000000")
+		So(len(ks.jwks().Keys), ShouldEqual, 2)
+	})
+
+	Convey("Check active signing key and public keys are read", t, func() {
+		h := apiHandler{}
+		So(h.readKeys(signedMethod, TestSignKeysPath, " "+TestVerifyKeysPath+","), ShouldBeNil)
+		So(len(h.verifyKeys), ShouldEqual, 1)
+
+		So(h.readKeys("", TestSignKeysPath, ""), ShouldBeNil)
+		So(h.signKey.alg, ShouldEqual, signedMethod)
+		So(h.verifyKeys, ShouldContainKey, h.signKey.id)
+
+		So(h.readKeys("none", TestSignKeysPath, ""), ShouldNotBeNil)
+	})
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	Convey("Check tokens of each signing algorithm are verified only by its keys", t, func() {
+		dir, err := ioutil.TempDir("", "keys")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		writeKey := func(name, blockType string, der []byte) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600), ShouldBeNil)
+			return path
+		}
+
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		ecPrivate, err := x509.MarshalECPrivateKey(ecKey)
+		So(err, ShouldBeNil)
+
+		edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		edPrivate, err := x509.MarshalPKCS8PrivateKey(edKey)
+		So(err, ShouldBeNil)
+		edPublicDER, err := x509.MarshalPKIXPublicKey(edPublic)
+		So(err, ShouldBeNil)
+
+		secret := filepath.Join(dir, "secret")
+		So(ioutil.WriteFile(secret, []byte(strings.Repeat("s", hmacMinSecretLen)+"\n"), 0600), ShouldBeNil)
+
+		keys := map[string]string{
+			signedMethodES256: writeKey("ec.pem", "EC PRIVATE KEY", ecPrivate),
+			signedMethodEdDSA: writeKey("ed.pem", "PRIVATE KEY", edPrivate),
+			signedMethodHS256: secret,
+		}
+
+		for alg, path := range keys {
+			// RSA public key verifies old tokens after the algorithm change
+			h := apiHandler{}
+			So(h.readKeys(alg, path, TestVerifyKeysPath), ShouldBeNil)
+
+			token, err := signToken(h.signKey, newTokenClaims(tokenType, tokenInfo{Source: alg}, time.Now().Add(time.Minute).Unix()))
+			So(err, ShouldBeNil)
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &tokenClaims{})
+			So(err, ShouldBeNil)
+			So(parsed.Method.Alg(), ShouldEqual, alg)
+
+			claims, err := h.verifyKeys.verify(token)
+			So(err, ShouldBeNil)
+			So(claims.Info.Source, ShouldEqual, alg)
+
+			_, err = getVerifyKeys(t).verify(token)
+			So(err, ShouldNotBeNil)
+		}
+
+		h := apiHandler{}
+		So(h.readKeys(signedMethod, TestSignKeysPath, writeKey("ed.pub", "PUBLIC KEY", edPublicDER)), ShouldBeNil)
+		So(len(h.verifyKeys), ShouldEqual, 2)
+		So(len(h.verifyKeys.jwks().Keys), ShouldEqual, 2)
+
+		// HS256 secret verifies old tokens after the change to RS256 and is never published
+		old := apiHandler{}
+		So(old.readKeys(signedMethodHS256, secret, ""), ShouldBeNil)
+		token, err := signToken(old.signKey, newTokenClaims(tokenType, tokenInfo{Source: "old"}, time.Now().Add(time.Minute).Unix()))
+		So(err, ShouldBeNil)
+
+		So(h.readKeys(signedMethod, TestSignKeysPath, secret), ShouldBeNil)
+		claims, err := h.verifyKeys.verify(token)
+		So(err, ShouldBeNil)
+		So(claims.Info.Source, ShouldEqual, "old")
+		So(len(h.verifyKeys.jwks().Keys), ShouldEqual, 1)
+
+		broken := filepath.Join(dir, "broken.pem")
+		So(ioutil.WriteFile(broken, []byte("-----BEGIN PUBLIC KEY-----\n"+strings.Repeat("A", hmacMinSecretLen)+"\n"), 0600), ShouldBeNil)
+		So(h.readKeys(signedMethod, TestSignKeysPath, broken), ShouldNotBeNil)
+	})
+
+	Convey("Check token can't choose HS256 with the public key as the secret", t, func() {
+		publicPEM, err := ioutil.ReadFile(TestVerifyKeysPath)
+		So(err, ShouldBeNil)
+
+		claims := newTokenClaims(tokenType, tokenInfo{Source: "attacker", Scopes: []string{scopeAdmin}}, time.Now().Add(time.Minute).Unix())
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		forged.Header[keyIDHeader] = keyID(getVerifyKey(t))
+		token, err := forged.SignedString(publicPEM)
+		So(err, ShouldBeNil)
+
+		h := apiHandler{}
+		So(h.readKeys(signedMethod, TestSignKeysPath, TestVerifyKeysPath), ShouldBeNil)
+		_, err = h.verifyKeys.verify(token)
+		So(err, ShouldNotBeNil)
+
+		_, err = getVerifyKeys(t).verify(token)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -16,24 +16,50 @@ const (
 	serverAddr     = "SERVER_ADDR"
 	tokenSignKey   = "SIGN_KEY"
 	tokenVerifyKey = "VERIFY_KEY"
+	tokenSignAlg   = "SIGN_ALG"
 	tokenExpireAt  = "TOKEN_EXPIRE_AT"
+	searchProfiles = "SEARCH_PROFILES"
+	analyticsKind  = "ANALYTICS_SINK"
+	experiments    = "EXPERIMENTS"
+	savedSearchURL = "SAVED_SEARCH_WEBHOOK"
+	savedSearchKey = "SAVED_SEARCH_WEBHOOK_SECRET"
+	migrations     = "MIGRATIONS"
+	refreshExpire  = "REFRESH_TOKEN_EXPIRE_AT"
+	tokenStore     = "TOKEN_STORE"
 )
 
 func main() {
 	godotenv.Load(envFile)
 
-	handlers, err := newAPIHandler(
-		os.Getenv(elasticAddr),
-		os.Getenv(tokenSignKey),
-		os.Getenv(tokenVerifyKey),
-		os.Getenv(tokenExpireAt))
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Getenv(elasticAddr), os.Getenv(migrations), os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	handlers, err := newAPIHandler(apiConfig{
+		ElasticAddr:        os.Getenv(elasticAddr),
+		MigrationsPath:     os.Getenv(migrations),
+		SignAlg:            os.Getenv(tokenSignAlg),
+		SignKey:            os.Getenv(tokenSignKey),
+		VerifyKeys:         os.Getenv(tokenVerifyKey),
+		TokenExpireAt:      os.Getenv(tokenExpireAt),
+		RefreshExpireAt:    os.Getenv(refreshExpire),
+		TokenStore:         os.Getenv(tokenStore),
+		ProfilesPath:       os.Getenv(searchProfiles),
+		ExperimentsPath:    os.Getenv(experiments),
+		AnalyticsKind:      os.Getenv(analyticsKind),
+		SavedSearchWebhook: os.Getenv(savedSearchURL),
+		SavedSearchSecret:  os.Getenv(savedSearchKey),
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	server := newServer(os.Getenv(serverAddr), handlers)
 
-	interruptSignal := make(chan os.Signal)
+	interruptSignal := make(chan os.Signal, 1)
 	done := make(chan bool)
 	signal.Notify(interruptSignal, os.Interrupt, syscall.SIGTERM)
 
@@ -1,37 +1,160 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// claimsFromContext returns claims of the token verified by authMiddleware
+func claimsFromContext(ctx context.Context) (tokenClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(tokenClaims)
+	return claims, ok
+}
+
+// bearerToken returns the token of the Bearer authorization scheme of RFC 6750,
+// the scheme is case insensitive. Raw tokens without the scheme are accepted
+// for compatibility with old clients
+func bearerToken(header string) string {
+	scheme := bearerTokenType + " "
+	if len(header) > len(scheme) && strings.EqualFold(header[:len(scheme)], scheme) {
+		return strings.TrimSpace(header[len(scheme):])
+	}
+
+	return header
+}
+
+// checkToken verifies the active token of the kind
+func (h *apiHandler) checkToken(token, kind string) (tokenClaims, error) {
+	claims, err := h.activeToken(token)
+	if err != nil {
+		return tokenClaims{}, err
+	}
+
+	if claims.TokenType != kind {
+		return tokenClaims{}, fmt.Errorf("token type is %q, expect %q", claims.TokenType, kind)
+	}
+
+	return claims, nil
+}
+
+// activeToken verifies the token and checks neither it, its refresh family nor the token
+// generation of its client is revoked, tokens issued before token ids were added can't be revoked
+func (h *apiHandler) activeToken(token string) (tokenClaims, error) {
+	claims, err := h.verifyKeys.verify(token)
+	if err != nil {
+		return tokenClaims{}, err
+	}
+
+	if claims.StandardClaims == nil || claims.Id == "" {
+		return claims, nil
+	}
+
+	for _, id := range []string{claims.Id, claims.Family, clientGenerationID(claims.Info.Source, claims.Info.Generation)} {
+		if id == "" {
+			continue
+		}
+
+		revoked, err := h.revocations.revoked(id)
+		if err != nil {
+			return tokenClaims{}, err
+		}
+
+		if revoked {
+			return tokenClaims{}, fmt.Errorf("token %s is revoked", claims.Id)
+		}
+	}
+
+	return claims, nil
+}
+
 // Middleware function, which will be called for each request
 func (h *apiHandler) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
-		case "/v1/auth":
+		case "/v1/auth", "/v1/oauth/token", "/v1/oauth/revoke", "/v1/oauth/introspect":
 			next.ServeHTTP(w, r)
 		default:
-			token := r.Header.Get("Authorization")
+			if key := r.Header.Get(apiKeyHeader); key != "" {
+				h.apiKeyAuth(w, r, key, next)
+				return
+			}
 
-			claims, err := verifyToken(token, h.verifyKey)
+			token := bearerToken(r.Header.Get("Authorization"))
+
+			claims, err := h.checkToken(token, tokenType)
 			if err != nil {
 				log.Errorf("error while verify token: %v", err)
+				w.Header().Set("WWW-Authenticate", bearerTokenType+` realm="searchapi"`)
 				h.writeError(w, http.StatusUnauthorized, "bad token")
 				return
 			}
 
 			log.Infof("auth new user success, token source is: %s", claims.Info.Source)
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
 		}
 	})
 }
 
+// requireScope allows the route only for tokens with the scope,
+// it should be used on routes behind authMiddleware
+func (h *apiHandler) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok || !claims.hasScope(scope) {
+			log.Infof("token of %s has no scope %s for %s %s", claims.Info.Source, scope, r.Method, r.URL.Path)
+			h.writeError(w, http.StatusForbidden, "token has no scope "+scope)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// apiKeyAuth authenticates the request by the API key instead of the token
+func (h *apiHandler) apiKeyAuth(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	apiKey, err := h.elastic.authenticateAPIKey(key)
+	if err == errBadCredentials {
+		log.Info("bad api key")
+		h.writeError(w, http.StatusUnauthorized, "bad api key")
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		h.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	log.Infof("auth by api key %s success", apiKey.ID)
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, apiKey.claims())))
+}
+
 // Middleware function, which will be called for each request
 func (h *apiHandler) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Infof("%s %s %v", r.Method, r.URL.Path, r.URL.Query())
+		log.Infof("%s %s %v", r.Method, r.URL.Path, redactedQuery(r.URL.Query()))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// redactedQuery returns the copy of the query with secret params replaced, so the
+// credentials of the legacy GET /v1/auth are not written to logs
+func redactedQuery(query url.Values) url.Values {
+	redacted := url.Values{}
+	for name, values := range query {
+		if name == "creds" {
+			values = []string{"[REDACTED]"}
+		}
+		redacted[name] = values
+	}
+
+	return redacted
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBearerToken(t *testing.T) {
+	Convey("Check token is read from Bearer scheme and raw header", t, func() {
+		So(bearerToken("Bearer abc.def.ghi"), ShouldEqual, "abc.def.ghi")
+		So(bearerToken("bearer  abc.def.ghi "), ShouldEqual, "abc.def.ghi")
+		So(bearerToken("abc.def.ghi"), ShouldEqual, "abc.def.ghi")
+		So(bearerToken("Bearer "), ShouldEqual, "Bearer ")
+		So(bearerToken(""), ShouldEqual, "")
+	})
+}
+
+func TestRedactedQuery(t *testing.T) {
+	Convey("Check credentials are not logged", t, func() {
+		query := url.Values{"creds": {"someid:somesecret"}, "q": {"shoes"}}
+		redacted := redactedQuery(query)
+		So(redacted.Get("creds"), ShouldNotContainSubstring, "somesecret")
+		So(redacted.Get("q"), ShouldEqual, "shoes")
+		So(query.Get("creds"), ShouldEqual, "someid:somesecret")
+	})
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	Convey("Check requests are authenticated by Bearer and raw tokens", t, func() {
+		h := apiHandler{revocations: newMemoryRevocations()}
+		So(h.readKeys(signedMethod, TestSignKeysPath, TestVerifyKeysPath), ShouldBeNil)
+
+		handler := h.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		serve := func(authorization string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/v1/products", nil)
+			r.Header.Set("Authorization", authorization)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+			return rr
+		}
+
+		token, err := signToken(rsaSigningKey(getSignKey(t)), newTokenClaims(tokenType, tokenInfo{Source: "000000000"}, time.Now().Add(time.Minute).Unix()))
+		So(err, ShouldBeNil)
+
+		So(serve("Bearer "+token).Code, ShouldEqual, http.StatusNoContent)
+		So(serve(token).Code, ShouldEqual, http.StatusNoContent)
+
+		rr := serve("Bearer " + token + "x")
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		So(rr.Header().Get("WWW-Authenticate"), ShouldStartWith, bearerTokenType)
+	})
+}
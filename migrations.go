@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMigrationsPath = "migrations"
+
+	schemaVersionsIndexName = "schema_versions"
+
+	// migrationLockID is the document of schema_versions held by the instance applying migrations
+	migrationLockID    = "migration_lock"
+	migrationLockTTL   = time.Hour
+	migrationLockRetry = 2 * time.Second
+
+	schemaVersionsMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"alias":{
+				"type":"keyword"
+			},
+			"version":{
+				"type":"integer"
+			},
+			"applied_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+// migration is a version of the documents index definition: settings, analyzers
+// and mappings. The file name starts with the version, like 0002_brand_keyword.json,
+// and the file contains the whole create index body, not a difference.
+type migration struct {
+	Version int
+	Name    string
+	Body    string
+}
+
+// SchemaVersion is the migration applied to the documents index of the collection
+type SchemaVersion struct {
+	Alias     string    `json:"alias"`
+	Version   int       `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// migrationLock is held by the instance applying migrations, other instances wait until
+// it's released, the lock of a crashed instance is taken over after it expires
+type migrationLock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// readMigrations loads migrations from the directory ordered by version,
+// empty path means the default directory
+func readMigrations(path string) ([]migration, error) {
+	if path == "" {
+		path = defaultMigrationsPath
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while read migrations")
+	}
+
+	migrations := []migration{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		m, err := readMigration(filepath.Join(path, file.Name()))
+		if err != nil {
+			return nil, wrapper.Wrapf(err, "bad migration %q", file.Name())
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	if len(migrations) == 0 {
+		return nil, errors.New("no migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %q should have version %d", m.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+func readMigration(path string) (migration, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+	if err != nil || version <= 0 {
+		return migration{}, errors.New("file name should start with the version")
+	}
+
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return migration{}, err
+	}
+
+	m := migration{Version: version, Name: name, Body: string(body)}
+	if _, err := m.properties(); err != nil {
+		return migration{}, err
+	}
+
+	return m, nil
+}
+
+// migrationMappings is the part of the index definition checked for drift
+type migrationMappings struct {
+	Mappings struct {
+		DynamicTemplates []map[string]struct {
+			PathMatch string `json:"path_match"`
+		} `json:"dynamic_templates"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"mappings"`
+}
+
+// properties returns mapped fields of the migration
+func (m migration) properties() (map[string]interface{}, error) {
+	body := migrationMappings{}
+	if err := json.Unmarshal([]byte(m.Body), &body); err != nil {
+		return nil, wrapper.Wrap(err, "error while parse migration")
+	}
+
+	if len(body.Mappings.Properties) == 0 {
+		return nil, errors.New("migration has no mappings properties")
+	}
+
+	return body.Mappings.Properties, nil
+}
+
+// dynamicFields returns object fields which subfields are mapped by dynamic templates
+func (m migration) dynamicFields() ([]string, error) {
+	body := migrationMappings{}
+	if err := json.Unmarshal([]byte(m.Body), &body); err != nil {
+		return nil, wrapper.Wrap(err, "error while parse migration")
+	}
+
+	fields := []string{}
+	for _, template := range body.Mappings.DynamicTemplates {
+		for _, t := range template {
+			if parts := strings.SplitN(t.PathMatch, ".", 2); len(parts) == 2 {
+				fields = append(fields, parts[0])
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// mappingDrift returns sorted names of fields which live mapping differs from expected
+func mappingDrift(expected, live map[string]interface{}) []string {
+	fields := map[string]bool{}
+	for field := range expected {
+		fields[field] = true
+	}
+	for field := range live {
+		fields[field] = true
+	}
+
+	drift := []string{}
+	for field := range fields {
+		if !reflect.DeepEqual(normalizeMapping(expected[field]), normalizeMapping(live[field])) {
+			drift = append(drift, field)
+		}
+	}
+
+	sort.Strings(drift)
+	return drift
+}
+
+// normalizeMapping returns the field mapping in the form elastic returns it: booleans and
+// numbers given as strings are parsed and the default object type of fields with properties
+// is omitted, so equal mappings written differently are not reported as drift
+func normalizeMapping(mapping interface{}) interface{} {
+	switch value := mapping.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, v := range value {
+			normalized[key] = normalizeMapping(v)
+		}
+		if _, ok := normalized["properties"]; ok && normalized["type"] == "object" {
+			delete(normalized, "type")
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for i, v := range value {
+			normalized[i] = normalizeMapping(v)
+		}
+		return normalized
+	case string:
+		if value == "true" || value == "false" {
+			return value == "true"
+		}
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+		return value
+	case int:
+		return float64(value)
+	default:
+		return value
+	}
+}
+
+// schema returns the latest migration
+func (ee *elasticEngine) schema() migration {
+	return ee.migrations[len(ee.migrations)-1]
+}
+
+// migrate applies pending migrations to the documents index of the collection in order,
+// each migration is reindexed to the new index version. The missing index is created
+// from the latest migration, an index created before migrations has the first one.
+func (ee *elasticEngine) migrate() error {
+	alias := ee.index
+	latest := ee.schema()
+
+	exists, err := ee.client.IndexExists(alias).Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while check index exists")
+	}
+
+	if !exists {
+		if err := ee.ensureAlias(alias, latest.Body); err != nil {
+			return err
+		}
+
+		return ee.recordVersion(alias, latest.Version)
+	}
+
+	applied, err := ee.appliedVersion(alias)
+	if err != nil {
+		return err
+	}
+
+	if applied == 0 {
+		applied = ee.migrations[0].Version
+		if err := ee.recordVersion(alias, applied); err != nil {
+			return err
+		}
+	}
+
+	if applied > latest.Version {
+		log.Warnf("index %s has version %d newer than the latest migration %d", alias, applied, latest.Version)
+		return nil
+	}
+
+	for _, m := range ee.migrations {
+		if m.Version <= applied {
+			continue
+		}
+
+		result, err := ee.reindex(m.Body)
+		if err != nil {
+			return wrapper.Wrapf(err, "error while apply migration %q to %s", m.Name, alias)
+		}
+
+		if err := ee.recordVersion(alias, m.Version); err != nil {
+			return err
+		}
+
+		log.Infof("migration %s applied to %s, %s moved to %s, %d documents", m.Name, alias, result.From, result.To, result.Count)
+	}
+
+	return nil
+}
+
+// migrateCollections applies pending migrations to the default and all other collections
+// and warns about collections which mapping was changed not by migrations.
+// Migrations are applied under the lock, so instances starting together don't reindex twice
+func (ee *elasticEngine) migrateCollections() error {
+	unlock, err := ee.lockMigrations()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	collections, err := ee.collections()
+	if err != nil {
+		return err
+	}
+
+	engines := []elasticEngine{*ee}
+	for _, c := range collections {
+		engines = append(engines, ee.forCollection(c))
+	}
+
+	for _, engine := range engines {
+		if err := engine.migrate(); err != nil {
+			return err
+		}
+
+		drift, err := engine.mappingDrift()
+		if err != nil {
+			return err
+		}
+
+		if len(drift) > 0 {
+			log.Warnf("mapping of %s differs from migration %s in fields %s", engine.index, engine.schema().Name, strings.Join(drift, ", "))
+		}
+	}
+
+	return nil
+}
+
+// mappingDrift compares the live mapping of the collection with the latest migration,
+// fields mapped by dynamic templates are skipped
+func (ee *elasticEngine) mappingDrift() ([]string, error) {
+	expected, err := ee.schema().properties()
+	if err != nil {
+		return nil, err
+	}
+
+	dynamic, err := ee.schema().dynamicFields()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ee.client.GetMapping().Index(ee.index).Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get mapping")
+	}
+
+	live := migrationMappings{}
+
+	// the alias resolves to one physical index
+	for _, index := range result {
+		body, err := json.Marshal(index)
+		if err != nil {
+			return nil, wrapper.Wrap(err, "error while marshal mapping")
+		}
+
+		if err := json.Unmarshal(body, &live); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal mapping")
+		}
+	}
+
+	for _, field := range dynamic {
+		delete(expected, field)
+		delete(live.Mappings.Properties, field)
+	}
+
+	return mappingDrift(expected, live.Mappings.Properties), nil
+}
+
+// lockMigrations waits until the migration lock is taken, the returned function releases it
+func (ee *elasticEngine) lockMigrations() (func(), error) {
+	lock := migrationLock{Owner: uuid.New().String()}
+	deadline := time.Now().Add(migrationLockTTL)
+
+	for {
+		lock.ExpiresAt = time.Now().UTC().Add(migrationLockTTL)
+		_, err := ee.client.Index().
+			Index(schemaVersionsIndexName).
+			Id(migrationLockID).
+			OpType("create").
+			BodyJson(lock).
+			Refresh("true").
+			Do(context.Background())
+		if err == nil {
+			break
+		}
+		if !elastic.IsConflict(err) {
+			return nil, wrapper.Wrap(err, "error while take migration lock")
+		}
+
+		if err := ee.releaseExpiredLock(); err != nil {
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("migration lock is held by another instance")
+		}
+
+		log.Info("migrations are applied by another instance, waiting")
+		time.Sleep(migrationLockRetry)
+	}
+
+	return func() {
+		_, err := ee.client.Delete().
+			Index(schemaVersionsIndexName).
+			Id(migrationLockID).
+			Refresh("true").
+			Do(context.Background())
+		if err != nil && !elastic.IsNotFound(err) {
+			log.Errorf("error while release migration lock: %v", err)
+		}
+	}, nil
+}
+
+// releaseExpiredLock deletes the migration lock of the instance which didn't release it in time
+func (ee *elasticEngine) releaseExpiredLock() error {
+	result, err := ee.client.Get().
+		Index(schemaVersionsIndexName).
+		Id(migrationLockID).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while get migration lock")
+	}
+
+	lock := migrationLock{}
+	if err := json.Unmarshal(result.Source, &lock); err != nil {
+		return wrapper.Wrap(err, "error while unmarshal migration lock")
+	}
+
+	if time.Now().Before(lock.ExpiresAt) {
+		return nil
+	}
+
+	log.Warnf("migration lock of %s expired at %s, taking it over", lock.Owner, lock.ExpiresAt)
+	_, err = ee.client.Delete().
+		Index(schemaVersionsIndexName).
+		Id(migrationLockID).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil && !elastic.IsNotFound(err) {
+		return wrapper.Wrap(err, "error while delete expired migration lock")
+	}
+
+	return nil
+}
+
+// appliedVersion returns the migration version of the alias, 0 if it's unknown
+func (ee *elasticEngine) appliedVersion(alias string) (int, error) {
+	result, err := ee.client.Get().
+		Index(schemaVersionsIndexName).
+		Id(alias).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, wrapper.Wrap(err, "error while get schema version")
+	}
+
+	version := SchemaVersion{}
+	if err := json.Unmarshal(result.Source, &version); err != nil {
+		return 0, wrapper.Wrap(err, "error while unmarshal schema version")
+	}
+
+	return version.Version, nil
+}
+
+func (ee *elasticEngine) recordVersion(alias string, version int) error {
+	_, err := ee.client.Index().
+		Index(schemaVersionsIndexName).
+		Id(alias).
+		BodyJson(SchemaVersion{Alias: alias, Version: version, AppliedAt: time.Now().UTC()}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while put schema version")
+	}
+
+	return nil
+}
+
+func (ee *elasticEngine) deleteVersion(alias string) error {
+	_, err := ee.client.Delete().
+		Index(schemaVersionsIndexName).
+		Id(alias).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil && !elastic.IsNotFound(err) {
+		return wrapper.Wrap(err, "error while delete schema version")
+	}
+
+	return nil
+}
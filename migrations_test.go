@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadMigrations(t *testing.T) {
+	Convey("Check migrations are read in order of versions", t, func() {
+		migrations, err := readMigrations("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(migrations[0].Version, ShouldEqual, 1)
+		So(migrations[0].Name, ShouldEqual, "0001_initial")
+
+		properties, err := migrations[0].properties()
+		if err != nil {
+			t.Fatal(err)
+		}
+		So(properties, ShouldContainKey, "key_words")
+
+		dynamic, err := migrations[len(migrations)-1].dynamicFields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		So(dynamic, ShouldContain, attributesField)
+
+		dir, err := ioutil.TempDir("", "migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		body := []byte(`{"mappings":{"properties":{"name":{"type":"text"}}}}`)
+		So(ioutil.WriteFile(filepath.Join(dir, "0002_second.json"), body, 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "0001_first.json"), body, 0644), ShouldBeNil)
+
+		migrations, err = readMigrations(dir)
+		So(err, ShouldBeNil)
+		So(len(migrations), ShouldEqual, 2)
+		So(migrations[1].Name, ShouldEqual, "0002_second")
+
+		So(ioutil.WriteFile(filepath.Join(dir, "0004_gap.json"), body, 0644), ShouldBeNil)
+		_, err = readMigrations(dir)
+		So(err, ShouldNotBeNil)
+
+		So(os.Remove(filepath.Join(dir, "0004_gap.json")), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "third.json"), body, 0644), ShouldBeNil)
+		_, err = readMigrations(dir)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMappingDrift(t *testing.T) {
+	Convey("Check mapping drift lists changed, missing and unknown fields", t, func() {
+		expected := map[string]interface{}{
+			"name":      map[string]interface{}{"type": "text"},
+			"brand":     map[string]interface{}{"type": "text"},
+			"key_words": map[string]interface{}{"type": "keyword"},
+		}
+		live := map[string]interface{}{
+			"name":  map[string]interface{}{"type": "text"},
+			"brand": map[string]interface{}{"type": "keyword"},
+			"color": map[string]interface{}{"type": "text"},
+		}
+
+		So(mappingDrift(expected, expected), ShouldBeEmpty)
+
+		written := map[string]interface{}{
+			"price":   map[string]interface{}{"type": "scaled_float", "scaling_factor": 100, "index": "true"},
+			"address": map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+		}
+		returned := map[string]interface{}{
+			"price":   map[string]interface{}{"type": "scaled_float", "scaling_factor": "100", "index": true},
+			"address": map[string]interface{}{"properties": map[string]interface{}{}},
+		}
+		So(mappingDrift(written, returned), ShouldBeEmpty)
+		So(mappingDrift(expected, live), ShouldResemble, []string{"brand", "color", "key_words"})
+	})
+}
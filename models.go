@@ -1,17 +1,145 @@
 package main
 
 import (
+	"errors"
 	"time"
 )
 
 type Doc struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Brand     string    `json:"brand"`
-	KeyWords  []string  `json:"key_words"`
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Name       string    `json:"name"`
+	Brand      string    `json:"brand"`
+	KeyWords   []string  `json:"key_words"`
+	Popularity float64   `json:"popularity"`
+
+	// SalePrice is the discounted price, it's lower than the price,
+	// the effective price is set on indexing to the sale price or the price
+	Price          float64 `json:"price,omitempty"`
+	SalePrice      float64 `json:"sale_price,omitempty"`
+	Currency       string  `json:"currency,omitempty"`
+	EffectivePrice float64 `json:"effective_price,omitempty"`
+
+	// Category is the taxonomy path like shoes > running > trail,
+	// categories are set on indexing to the category with all its ancestors
+	Category   string   `json:"category,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+
+	Variants []Variant `json:"variants,omitempty"`
+
+	// Stock is nil when the stock of the product isn't tracked,
+	// empty availability is set from the stock on indexing
+	Stock        *int         `json:"stock,omitempty"`
+	Availability string       `json:"availability,omitempty"`
+	Stores       []StoreStock `json:"stores,omitempty"`
+
+	// Locations are set on indexing to the product location and locations of its stores
+	Location  *GeoPoint  `json:"location,omitempty"`
+	Locations []GeoPoint `json:"locations,omitempty"`
+}
+
+// StoreStock is the stock of the product in the store location
+type StoreStock struct {
+	StoreID      string    `json:"store_id"`
+	Stock        int       `json:"stock"`
+	Availability string    `json:"availability,omitempty"`
+	Location     *GeoPoint `json:"location,omitempty"`
+}
+
+// Variant is a purchasable option of the product, like size and color of the shoe
+type Variant struct {
+	SKU   string  `json:"sku"`
+	Size  string  `json:"size,omitempty"`
+	Color string  `json:"color,omitempty"`
+	Price float64 `json:"price,omitempty"`
+	Stock int     `json:"stock"`
+}
+
+func (d *Doc) validate() error {
+	if err := validatePopularity(d.Popularity); err != nil {
+		return err
+	}
+
+	if err := d.validatePrice(); err != nil {
+		return err
+	}
+
+	if d.Category != "" {
+		if _, err := normalizeCategory(d.Category); err != nil {
+			return err
+		}
+	}
+
+	if err := validateVariants(d.Variants); err != nil {
+		return err
+	}
+
+	if err := d.validateStock(); err != nil {
+		return err
+	}
+
+	if err := d.validateLocations(); err != nil {
+		return err
+	}
+
+	return validateAttributes(d.Attributes)
+}
+
+// Popularity is a body of the product popularity update
+type Popularity struct {
+	Popularity float64 `json:"popularity"`
+}
+
+// validatePopularity rejects negative popularity, modifiers of search profiles
+// are safe only for non-negative values
+func validatePopularity(popularity float64) error {
+	if popularity < 0 {
+		return errors.New("popularity should not be negative")
+	}
+
+	return nil
 }
 
 type Token struct {
 	Token string `json:"token"`
 }
+
+// Hit is a document returned by search together with the details of how it was ranked,
+// matching variants are listed when the search has variant filters and the distance
+// to the nearest location when the search has lat and lon
+type Hit struct {
+	Doc
+	Promoted         bool      `json:"promoted,omitempty"`
+	MatchingVariants []Variant `json:"matching_variants,omitempty"`
+	DistanceKm       *float64  `json:"distance_km,omitempty"`
+}
+
+// SearchResponse is the search result of v2, v1 search rejects facets and
+// always returns only the array of hits
+type SearchResponse struct {
+	SearchID string                   `json:"search_id,omitempty"`
+	Hits     []Hit                    `json:"hits"`
+	Total    int64                    `json:"total"`
+	Facets   map[string][]FacetBucket `json:"facets"`
+}
+
+// Rule is a merchandising rule of the collection, which pins, boosts or buries
+// products for search queries matching the pattern
+type Rule struct {
+	ID         string    `json:"id"`
+	Collection string    `json:"collection"`
+	Pattern    string    `json:"pattern"`
+	Pins       []Pin     `json:"pins,omitempty"`
+	Boost      []string  `json:"boost,omitempty"`
+	Bury       []string  `json:"bury,omitempty"`
+	StartAt    time.Time `json:"start_at"`
+	EndAt      time.Time `json:"end_at"`
+}
+
+// Pin places the product on the given position (starting from 1) of the result
+type Pin struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	grantClientCredentials = "client_credentials"
+	grantRefreshToken      = "refresh_token"
+	bearerTokenType        = "Bearer"
+
+	defaultRefreshExpireAt = 30 * 24 * 60 * 60
+
+	// OAuth2 error codes of RFC 6749 section 5.2
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthInvalidScope         = "invalid_scope"
+	oauthInvalidGrant         = "invalid_grant"
+	oauthUnauthorizedClient   = "unauthorized_client"
+)
+
+// OAuthToken is the successful token response of RFC 6749 section 5.1
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+var errInvalidGrant = errors.New("refresh token is invalid, expired, revoked or issued to another client")
+
+// TokenIntrospection is the introspection response of RFC 7662 section 2.2,
+// inactive tokens have only active field
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+// OAuthError is the error response of RFC 6749 section 5.2
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// oauthClientCredentials reads client credentials from HTTP Basic authorization
+// or from the form, using both is an error
+func oauthClientCredentials(r *http.Request) (string, string, error) {
+	id, secret, basic := r.BasicAuth()
+	formID, formSecret := r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+
+	switch {
+	case basic && formSecret != "":
+		return "", "", errors.New("client credentials should be sent in one way")
+	case basic:
+		return id, secret, nil
+	case formID != "" && formSecret != "":
+		return formID, formSecret, nil
+	default:
+		return "", "", errors.New("client credentials are missing")
+	}
+}
+
+// introspection describes the active token, the access token type is the one
+// of the token response and the refresh token type is the grant name
+func introspection(claims tokenClaims) TokenIntrospection {
+	ti := TokenIntrospection{
+		Active:    true,
+		Scope:     strings.Join(claims.Info.Scopes, " "),
+		ClientID:  claims.Info.Source,
+		TokenType: bearerTokenType,
+		Sub:       claims.Info.Source,
+		Tenant:    claims.Info.Tenant,
+	}
+
+	if claims.TokenType == refreshTokenType {
+		ti.TokenType = grantRefreshToken
+	}
+
+	if claims.StandardClaims != nil {
+		ti.Exp = claims.ExpiresAt
+		ti.Iat = claims.IssuedAt
+		ti.Jti = claims.Id
+	}
+
+	return ti
+}
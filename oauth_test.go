@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func oauthRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest("POST", "/v1/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	Convey("Check client credentials are read from basic auth or form", t, func() {
+		r := oauthRequest(url.Values{"grant_type": {grantClientCredentials}})
+		r.SetBasicAuth("partner", "secret")
+		So(r.ParseForm(), ShouldBeNil)
+		id, secret, err := oauthClientCredentials(r)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "partner")
+		So(secret, ShouldEqual, "secret")
+
+		r = oauthRequest(url.Values{"client_id": {"partner"}, "client_secret": {"secret"}})
+		So(r.ParseForm(), ShouldBeNil)
+		id, _, err = oauthClientCredentials(r)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "partner")
+
+		r = oauthRequest(url.Values{"client_id": {"partner"}, "client_secret": {"secret"}})
+		r.SetBasicAuth("partner", "secret")
+		So(r.ParseForm(), ShouldBeNil)
+		_, _, err = oauthClientCredentials(r)
+		So(err, ShouldNotBeNil)
+
+		r = oauthRequest(url.Values{})
+		So(r.ParseForm(), ShouldBeNil)
+		_, _, err = oauthClientCredentials(r)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPostOAuthTokenV1(t *testing.T) {
+	Convey("Check OAuth2 token endpoint", t, func() {
+		h := apiHandler{tokenExpireAt: 3600, refreshExpireAt: 7200, revocations: newMemoryRevocations()}
+		if err := h.readKeys(signedMethod, TestSignKeysPath, TestVerifyKeysPath); err != nil {
+			t.Fatal(err)
+		}
+
+		creds := os.Getenv("CLIENT_CREDS")
+		os.Setenv("CLIENT_CREDS", "partner:secret")
+		defer os.Setenv("CLIENT_CREDS", creds)
+
+		rr := httptest.NewRecorder()
+		r := oauthRequest(url.Values{"grant_type": {"password"}})
+		h.postOAuthTokenV1(rr, r)
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		So(rr.Body.String(), ShouldContainSubstring, oauthUnsupportedGrantType)
+
+		rr = httptest.NewRecorder()
+		r = oauthRequest(url.Values{"grant_type": {grantClientCredentials}})
+		r.SetBasicAuth("partner", "secret")
+		h.postOAuthTokenV1(rr, r)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+
+		token := OAuthToken{}
+		So(json.Unmarshal(rr.Body.Bytes(), &token), ShouldBeNil)
+		So(token.TokenType, ShouldEqual, bearerTokenType)
+		So(token.ExpiresIn, ShouldEqual, 3600)
+		So(token.Scope, ShouldEqual, scopeAdmin)
+
+		claims, err := h.verifyKeys.verify(token.AccessToken)
+		So(err, ShouldBeNil)
+		So(claims.Info.Source, ShouldEqual, "partner")
+		So(claims.Info.Scopes, ShouldResemble, []string{scopeAdmin})
+		So(claims.Id, ShouldNotBeEmpty)
+	})
+}
+
+func TestOAuthRefreshToken(t *testing.T) {
+	Convey("Check refresh tokens are rotated and revoked", t, func() {
+		h := apiHandler{tokenExpireAt: 3600, refreshExpireAt: 7200, revocations: newMemoryRevocations()}
+		if err := h.readKeys(signedMethod, TestSignKeysPath, TestVerifyKeysPath); err != nil {
+			t.Fatal(err)
+		}
+
+		creds := os.Getenv("CLIENT_CREDS")
+		os.Setenv("CLIENT_CREDS", "partner:secret")
+		defer os.Setenv("CLIENT_CREDS", creds)
+
+		post := func(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			r := oauthRequest(form)
+			r.SetBasicAuth("partner", "secret")
+			handler(rr, r)
+			return rr
+		}
+
+		refresh := func(refreshToken string) (OAuthToken, int) {
+			rr := post(h.postOAuthTokenV1, url.Values{"grant_type": {grantRefreshToken}, "refresh_token": {refreshToken}})
+			token := OAuthToken{}
+			json.Unmarshal(rr.Body.Bytes(), &token)
+			return token, rr.Code
+		}
+
+		first := OAuthToken{}
+		So(json.Unmarshal(post(h.postOAuthTokenV1, url.Values{"grant_type": {grantClientCredentials}}).Body.Bytes(), &first), ShouldBeNil)
+		So(first.RefreshToken, ShouldNotBeEmpty)
+
+		_, code := refresh(first.AccessToken)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		second, code := refresh(first.RefreshToken)
+		So(code, ShouldEqual, http.StatusOK)
+		So(second.RefreshToken, ShouldNotEqual, first.RefreshToken)
+
+		third, code := refresh(second.RefreshToken)
+		So(code, ShouldEqual, http.StatusOK)
+
+		// reuse of the rotated token revokes the whole family
+		_, code = refresh(second.RefreshToken)
+		So(code, ShouldEqual, http.StatusBadRequest)
+		_, code = refresh(third.RefreshToken)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		// access tokens of the revoked family are revoked too
+		_, err := h.checkToken(third.AccessToken, tokenType)
+		So(err, ShouldNotBeNil)
+		_, err = h.checkToken(third.RefreshToken, tokenType)
+		So(err, ShouldNotBeNil)
+
+		fourth := OAuthToken{}
+		So(json.Unmarshal(post(h.postOAuthTokenV1, url.Values{"grant_type": {grantClientCredentials}}).Body.Bytes(), &fourth), ShouldBeNil)
+		_, err = h.checkToken(fourth.AccessToken, tokenType)
+		So(err, ShouldBeNil)
+
+		rr := post(h.postOAuthRevokeV1, url.Values{"token": {fourth.AccessToken}})
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		_, err = h.checkToken(fourth.AccessToken, tokenType)
+		So(err, ShouldNotBeNil)
+
+		rr = post(h.postOAuthRevokeV1, url.Values{"token": {"not a token"}})
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	})
+}
+
+func TestPostOAuthIntrospectV1(t *testing.T) {
+	Convey("Check OAuth2 token introspection", t, func() {
+		h := apiHandler{tokenExpireAt: 3600, refreshExpireAt: 7200, revocations: newMemoryRevocations()}
+		if err := h.readKeys(signedMethod, TestSignKeysPath, TestVerifyKeysPath); err != nil {
+			t.Fatal(err)
+		}
+
+		creds := os.Getenv("CLIENT_CREDS")
+		os.Setenv("CLIENT_CREDS", "gateway:secret")
+		defer os.Setenv("CLIENT_CREDS", creds)
+
+		introspect := func(token string) TokenIntrospection {
+			rr := httptest.NewRecorder()
+			r := oauthRequest(url.Values{"token": {token}})
+			r.SetBasicAuth("gateway", "secret")
+			h.postOAuthIntrospectV1(rr, r)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+
+			result := TokenIntrospection{}
+			So(json.Unmarshal(rr.Body.Bytes(), &result), ShouldBeNil)
+			return result
+		}
+
+		token, err := h.issueOAuthToken(Client{ID: "partner", Tenant: "shop"}, []string{scopeProductsRead}, "")
+		So(err, ShouldBeNil)
+
+		result := introspect(token.AccessToken)
+		So(result.Active, ShouldBeTrue)
+		So(result.ClientID, ShouldEqual, "partner")
+		So(result.Scope, ShouldEqual, scopeProductsRead)
+		So(result.TokenType, ShouldEqual, bearerTokenType)
+		So(result.Tenant, ShouldEqual, "shop")
+		So(result.Exp, ShouldBeGreaterThan, time.Now().Unix())
+
+		result = introspect(token.RefreshToken)
+		So(result.Active, ShouldBeTrue)
+		So(result.TokenType, ShouldEqual, grantRefreshToken)
+
+		claims, err := h.verifyKeys.verify(token.RefreshToken)
+		So(err, ShouldBeNil)
+		So(h.revokeRefreshFamily(claims.Family), ShouldBeNil)
+		So(introspect(token.RefreshToken), ShouldResemble, TokenIntrospection{})
+
+		So(introspect("not a token"), ShouldResemble, TokenIntrospection{})
+
+		rr := httptest.NewRecorder()
+		r := oauthRequest(url.Values{})
+		r.SetBasicAuth("gateway", "secret")
+		h.postOAuthIntrospectV1(rr, r)
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+	})
+}
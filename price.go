@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	priceField = "price"
+	// effectivePriceField is the sale price of products on sale, otherwise the price,
+	// it's set on indexing and missing for products without price
+	effectivePriceField = "effective_price"
+	// priceFacet is the built-in facet, a histogram by price_interval or ranges by price_ranges
+	priceFacet = "price"
+
+	defaultPriceInterval = 50
+	minPriceInterval     = 1
+	// maxPriceBuckets keeps the price histogram under the elastic limit of buckets (search.max_buckets),
+	// the interval is widened when the price range of found products needs more buckets
+	maxPriceBuckets = 1000
+
+	// effectivePriceScript sets the effective price of documents copied to the new index version
+	effectivePriceScript = `
+if (ctx._source.sale_price != null && ctx._source.sale_price > 0) {
+	ctx._source.effective_price = ctx._source.sale_price;
+} else if (ctx._source.price != null && ctx._source.price > 0) {
+	ctx._source.effective_price = ctx._source.price;
+}
+`
+)
+
+var currencyRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// priceRange is a bucket of the price range facet, nil bound means unbounded
+type priceRange struct {
+	from *float64
+	to   *float64
+}
+
+// validatePrice checks the price has ISO 4217 currency and the sale price is lower than the price
+func (d *Doc) validatePrice() error {
+	if d.Price < 0 || d.SalePrice < 0 {
+		return errors.New("price should not be negative")
+	}
+
+	if d.SalePrice > 0 && d.SalePrice >= d.Price {
+		return errors.New("sale_price should be lower than price")
+	}
+
+	if d.Price > 0 && !currencyRegexp.MatchString(d.Currency) {
+		return errors.New("currency should be ISO 4217 code like EUR")
+	}
+
+	return nil
+}
+
+// setEffectivePrice sets the price products are filtered, sorted and bucketed by,
+// products on sale have the sale price
+func (d *Doc) setEffectivePrice() {
+	d.EffectivePrice = d.Price
+	if d.SalePrice > 0 {
+		d.EffectivePrice = d.SalePrice
+	}
+}
+
+// parsePrice parses optional price bound of the query
+func parsePrice(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		return nil, fmt.Errorf("can't parse price %q", value)
+	}
+
+	return &price, nil
+}
+
+// parsePriceRanges parses ranges in format from-to separated by comma, like 0-50,50-100,100-
+func parsePriceRanges(value string) ([]priceRange, error) {
+	ranges := []priceRange{}
+	for _, r := range strings.Split(value, ",") {
+		bounds := strings.Split(r, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("can't parse price range %q, expect from-to", r)
+		}
+
+		from, err := parsePrice(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := parsePrice(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if from == nil && to == nil {
+			return nil, fmt.Errorf("price range %q has no bounds", r)
+		}
+
+		ranges = append(ranges, priceRange{from: from, to: to})
+	}
+
+	return ranges, nil
+}
+
+// addPriceFilter narrows the query to the effective price range in the currency,
+// products on sale are filtered by the sale price
+func addPriceFilter(q elastic.Query, params queryParams) elastic.Query {
+	if params.priceMin == nil && params.priceMax == nil && params.currency == "" {
+		return q
+	}
+
+	query := elastic.NewBoolQuery().Must(q)
+	if params.priceMin != nil || params.priceMax != nil {
+		priceRange := elastic.NewRangeQuery(effectivePriceField)
+		if params.priceMin != nil {
+			priceRange.Gte(*params.priceMin)
+		}
+		if params.priceMax != nil {
+			priceRange.Lte(*params.priceMax)
+		}
+		query.Filter(priceRange)
+	}
+
+	if params.currency != "" {
+		query.Filter(elastic.NewTermQuery("currency", params.currency))
+	}
+
+	return query
+}
+
+// priceSort sorts products by the effective price, products without price are the last
+func priceSort(params queryParams) elastic.Sorter {
+	return elastic.NewFieldSort(effectivePriceField).Order(params.asc).Missing("_last")
+}
+
+// priceAggregation buckets products by the effective price, products without price are skipped
+func priceAggregation(params queryParams) elastic.Aggregation {
+	if len(params.priceRanges) == 0 {
+		return elastic.NewHistogramAggregation().Field(effectivePriceField).Interval(params.priceInterval).MinDocCount(1)
+	}
+
+	aggregation := elastic.NewRangeAggregation().Field(effectivePriceField)
+	for _, r := range params.priceRanges {
+		switch {
+		case r.from == nil:
+			aggregation.AddUnboundedFrom(*r.to)
+		case r.to == nil:
+			aggregation.AddUnboundedTo(*r.from)
+		default:
+			aggregation.AddRange(*r.from, *r.to)
+		}
+	}
+
+	return aggregation
+}
+
+// priceHistogramInterval widens the interval so the price range from min to max
+// fits into maxPriceBuckets buckets
+func priceHistogramInterval(interval, min, max float64) float64 {
+	if max <= min {
+		return interval
+	}
+
+	if minInterval := math.Ceil((max - min) / maxPriceBuckets); interval < minInterval {
+		return minInterval
+	}
+
+	return interval
+}
+
+// priceInterval returns the histogram interval of the price facet for products found by the query
+func (ee *elasticEngine) priceInterval(query elastic.Query, params queryParams) (float64, error) {
+	result, err := ee.client.Search().
+		Index(ee.index).
+		Query(query).
+		Size(0).
+		Aggregation("min", elastic.NewMinAggregation().Field(effectivePriceField)).
+		Aggregation("max", elastic.NewMaxAggregation().Field(effectivePriceField)).
+		Do(context.Background())
+	if err != nil {
+		return 0, wrapper.Wrap(err, "error while get price range")
+	}
+
+	min, okMin := result.Aggregations.Min("min")
+	max, okMax := result.Aggregations.Max("max")
+	if !okMin || !okMax || min.Value == nil || max.Value == nil {
+		return params.priceInterval, nil
+	}
+
+	return priceHistogramInterval(params.priceInterval, *min.Value, *max.Value), nil
+}
+
+func priceBuckets(aggregations elastic.Aggregations, params queryParams) ([]FacetBucket, error) {
+	if len(params.priceRanges) == 0 {
+		histogram, ok := aggregations.Histogram(priceFacet)
+		if !ok {
+			return nil, errors.New("no aggregation of price facet")
+		}
+
+		buckets := make([]FacetBucket, 0, len(histogram.Buckets))
+		for _, bucket := range histogram.Buckets {
+			from := bucket.Key
+			to := bucket.Key + params.priceInterval
+			buckets = append(buckets, FacetBucket{Value: bucket.Key, Count: bucket.DocCount, From: &from, To: &to})
+		}
+
+		return buckets, nil
+	}
+
+	ranges, ok := aggregations.Range(priceFacet)
+	if !ok {
+		return nil, errors.New("no aggregation of price facet")
+	}
+
+	buckets := make([]FacetBucket, 0, len(ranges.Buckets))
+	for _, bucket := range ranges.Buckets {
+		buckets = append(buckets, FacetBucket{Value: bucket.Key, Count: bucket.DocCount, From: bucket.From, To: bucket.To})
+	}
+
+	return buckets, nil
+}
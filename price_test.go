@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidatePrice(t *testing.T) {
+	Convey("Check product price validation", t, func() {
+		So((&Doc{}).validate(), ShouldBeNil)
+		So((&Doc{Price: 100, SalePrice: 80, Currency: "EUR"}).validate(), ShouldBeNil)
+
+		So((&Doc{Price: 100}).validate(), ShouldNotBeNil)
+		So((&Doc{Price: 100, Currency: "eur"}).validate(), ShouldNotBeNil)
+		So((&Doc{Price: 100, SalePrice: 120, Currency: "EUR"}).validate(), ShouldNotBeNil)
+		So((&Doc{SalePrice: 10, Currency: "EUR"}).validate(), ShouldNotBeNil)
+		So((&Doc{Price: -1, Currency: "EUR"}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestSetEffectivePrice(t *testing.T) {
+	Convey("Check products on sale are indexed with the sale price", t, func() {
+		doc := Doc{Price: 100, SalePrice: 80, Currency: "EUR"}
+		doc.setEffectivePrice()
+		So(doc.EffectivePrice, ShouldEqual, 80)
+
+		doc = Doc{Price: 100, Currency: "EUR"}
+		doc.setEffectivePrice()
+		So(doc.EffectivePrice, ShouldEqual, 100)
+
+		doc = Doc{}
+		doc.setEffectivePrice()
+		So(doc.EffectivePrice, ShouldEqual, 0)
+	})
+}
+
+func TestPriceParams(t *testing.T) {
+	Convey("Check price filters and facet params", t, func() {
+		params := queryParams{}
+		r := httptest.NewRequest("GET", "/v1/products?price_min=10&price_max=99.5&currency=EUR&facets=price", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(params.validate(), ShouldBeNil)
+		So(*params.priceMin, ShouldEqual, 10)
+		So(*params.priceMax, ShouldEqual, 99.5)
+		So(params.currency, ShouldEqual, "EUR")
+		So(params.priceInterval, ShouldEqual, defaultPriceInterval)
+
+		params = queryParams{}
+		r = httptest.NewRequest("GET", "/v1/products?price_ranges=-50,50-100,100-&facets=price", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(len(params.priceRanges), ShouldEqual, 3)
+		So(params.priceRanges[0].from, ShouldBeNil)
+		So(*params.priceRanges[0].to, ShouldEqual, 50)
+		So(params.priceRanges[2].to, ShouldBeNil)
+
+		params = queryParams{}
+		r = httptest.NewRequest("GET", "/v1/products?price_min=100&price_max=10&currency=EUR", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+
+		params = queryParams{}
+		r = httptest.NewRequest("GET", "/v1/products?price_max=100", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+
+		params = queryParams{}
+		r = httptest.NewRequest("GET", "/v1/products?facets=price&price_interval=0.001", nil)
+		So(params.fill(r), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+
+		So((&queryParams{}).fill(httptest.NewRequest("GET", "/v1/products?price_ranges=-", nil)), ShouldNotBeNil)
+		So((&queryParams{}).fill(httptest.NewRequest("GET", "/v1/products?price_min=cheap", nil)), ShouldNotBeNil)
+	})
+}
+
+func TestPriceAggregation(t *testing.T) {
+	Convey("Check price facet buckets the indexed effective price", t, func() {
+		params := queryParams{priceInterval: 10}
+		source, err := priceAggregation(params).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := json.Marshal(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(string(body), ShouldContainSubstring, `"field":"effective_price"`)
+		So(string(body), ShouldContainSubstring, `"interval":10`)
+
+		So(priceHistogramInterval(10, 0, 5000), ShouldEqual, 10)
+		So(priceHistogramInterval(1, 0, 1000000), ShouldEqual, 1000)
+		So(priceHistogramInterval(1, 0.5, 1500.5), ShouldEqual, 2)
+		So(priceHistogramInterval(50, 10, 10), ShouldEqual, 50)
+	})
+}
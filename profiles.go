@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	defaultProfileName = "default"
+	defaultBoostMode   = "multiply"
+)
+
+// popularityModifiers are field_value_factor modifiers which are finite for any
+// non-negative popularity, log, ln and reciprocal fail on documents with popularity 0
+var popularityModifiers = map[string]bool{
+	"":       true,
+	"none":   true,
+	"log1p":  true,
+	"log2p":  true,
+	"ln1p":   true,
+	"ln2p":   true,
+	"sqrt":   true,
+	"square": true,
+}
+
+// searchProfile is a named ranking configuration, which wraps the search query
+// into function_score with recency decay and popularity factor
+type searchProfile struct {
+	Name       string            `json:"name"`
+	Recency    *recencyDecay     `json:"recency"`
+	Popularity *popularityFactor `json:"popularity"`
+	BoostMode  string            `json:"boost_mode"`
+}
+
+// recencyDecay lowers the score of documents by created_at distance from now
+type recencyDecay struct {
+	Scale  string  `json:"scale"`
+	Offset string  `json:"offset"`
+	Decay  float64 `json:"decay"`
+	Weight float64 `json:"weight"`
+}
+
+// popularityFactor raises the score of documents by popularity value
+type popularityFactor struct {
+	Factor   float64 `json:"factor"`
+	Modifier string  `json:"modifier"`
+	Weight   float64 `json:"weight"`
+}
+
+// readProfiles loads search profiles from JSON file, empty path means no profiles
+func readProfiles(path string) (map[string]searchProfile, error) {
+	profiles := map[string]searchProfile{}
+	if path == "" {
+		return profiles, nil
+	}
+
+	profilesBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while read search profiles")
+	}
+
+	list := []searchProfile{}
+	if err := json.Unmarshal(profilesBytes, &list); err != nil {
+		return nil, wrapper.Wrap(err, "error while parse search profiles")
+	}
+
+	for _, profile := range list {
+		if err := profile.validate(); err != nil {
+			return nil, wrapper.Wrapf(err, "bad search profile %q", profile.Name)
+		}
+
+		profiles[profile.Name] = profile
+	}
+
+	return profiles, nil
+}
+
+func (p *searchProfile) validate() error {
+	if p.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if p.Recency != nil && p.Recency.Scale == "" {
+		return errors.New("recency scale is empty")
+	}
+
+	if p.Popularity != nil {
+		if !popularityModifiers[p.Popularity.Modifier] {
+			return fmt.Errorf("popularity modifier %q is not supported", p.Popularity.Modifier)
+		}
+
+		if p.Popularity.Factor < 0 {
+			return errors.New("popularity factor should not be negative")
+		}
+	}
+
+	return nil
+}
+
+// scores reports whether the profile changes scores of documents,
+// nil profile and profile without functions keep them
+func (p *searchProfile) scores() bool {
+	return p != nil && (p.Recency != nil || p.Popularity != nil)
+}
+
+// query wraps q into function_score, nil profile or profile without functions
+// returns q as is
+func (p *searchProfile) query(q elastic.Query) elastic.Query {
+	if !p.scores() {
+		return q
+	}
+
+	boostMode := p.BoostMode
+	if boostMode == "" {
+		boostMode = defaultBoostMode
+	}
+
+	fsq := elastic.NewFunctionScoreQuery().
+		Query(q).
+		ScoreMode("sum").
+		BoostMode(boostMode)
+
+	if p.Recency != nil {
+		decay := elastic.NewGaussDecayFunction().
+			FieldName("created_at").
+			Origin("now").
+			Scale(p.Recency.Scale)
+		if p.Recency.Offset != "" {
+			decay.Offset(p.Recency.Offset)
+		}
+		if p.Recency.Decay > 0 {
+			decay.Decay(p.Recency.Decay)
+		}
+		if p.Recency.Weight > 0 {
+			decay.Weight(p.Recency.Weight)
+		}
+
+		fsq.AddScoreFunc(decay)
+	}
+
+	if p.Popularity != nil {
+		factor := elastic.NewFieldValueFactorFunction().
+			Field("popularity").
+			Missing(0)
+		if p.Popularity.Factor > 0 {
+			factor.Factor(p.Popularity.Factor)
+		}
+		if p.Popularity.Modifier != "" {
+			factor.Modifier(p.Popularity.Modifier)
+		}
+		if p.Popularity.Weight > 0 {
+			factor.Weight(p.Popularity.Weight)
+		}
+
+		fsq.AddScoreFunc(factor)
+	}
+
+	return fsq
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadProfiles(t *testing.T) {
+	Convey("Check read search profiles", t, func() {
+		profiles, err := readProfiles("profiles.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(profiles, ShouldContainKey, defaultProfileName)
+		So(profiles["popular"].Popularity, ShouldNotBeNil)
+
+		profiles, err = readProfiles("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(len(profiles), ShouldEqual, 0)
+	})
+}
+
+func TestProfileValidate(t *testing.T) {
+	Convey("Check popularity modifiers are safe for popularity 0", t, func() {
+		profile := searchProfile{Name: "popular", Popularity: &popularityFactor{Modifier: "log1p"}}
+		So(profile.validate(), ShouldBeNil)
+
+		for _, modifier := range []string{"log", "ln", "reciprocal", "exp"} {
+			profile.Popularity.Modifier = modifier
+			So(profile.validate(), ShouldNotBeNil)
+		}
+
+		profile.Popularity = &popularityFactor{Factor: -1}
+		So(profile.validate(), ShouldNotBeNil)
+
+		So(validatePopularity(0), ShouldBeNil)
+		So(validatePopularity(-0.5), ShouldNotBeNil)
+		So((&Doc{Popularity: -1}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestProfileQuery(t *testing.T) {
+	Convey("Check search profile function score", t, func() {
+		q := elastic.NewMatchAllQuery()
+
+		var profile *searchProfile
+		So(profile.query(q), ShouldEqual, q)
+
+		profile = &searchProfile{
+			Name:       "test",
+			Recency:    &recencyDecay{Scale: "10d", Weight: 2},
+			Popularity: &popularityFactor{Modifier: "log1p"},
+		}
+
+		source, err := profile.query(q).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := json.Marshal(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(string(body), ShouldContainSubstring, `"gauss":{"created_at":{"origin":"now","scale":"10d"}`)
+		So(string(body), ShouldContainSubstring, `"field_value_factor":{"field":"popularity","missing":0,"modifier":"log1p"}`)
+		So(string(body), ShouldContainSubstring, `"boost_mode":"multiply"`)
+	})
+}
+
+func TestProfileDefaultSort(t *testing.T) {
+	Convey("Check searches with scoring profile are sorted by score", t, func() {
+		params := queryParams{ranking: &searchProfile{Name: "test"}}
+		So(params.defaultSort(merchandising{}), ShouldEqual, defaultSortField)
+
+		params.ranking.Popularity = &popularityFactor{Modifier: "log1p"}
+		So(params.defaultSort(merchandising{}), ShouldEqual, scoreSortField)
+	})
+}
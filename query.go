@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -15,6 +17,28 @@ type queryParams struct {
 	filterValue string
 	limit       int
 	offset      int
+	profile     string
+	attributes  []attributeFilter
+	facets      []string
+
+	priceMin      *float64
+	priceMax      *float64
+	currency      string
+	priceInterval float64
+	priceRanges   []priceRange
+
+	category string
+	variants []variantFilter
+
+	inStock *bool
+	store   string
+
+	geo    *GeoPoint
+	radius string
+
+	ranking    *searchProfile
+	experiment string
+	variant    string
 }
 
 func (qp *queryParams) fill(r *http.Request) error {
@@ -47,12 +71,68 @@ func (qp *queryParams) fill(r *http.Request) error {
 	filter, ok := values["filter"]
 	if ok {
 		if len(filter) > 0 {
-			filterParams := strings.Split(filter[0], ":")
-			if len(filterParams) < 2 {
-				return errors.New("can't parse filter params, expect field:value")
+			field, value, err := parseFilter(filter[0])
+			if err != nil {
+				return err
 			}
-			qp.filterField = filterParams[0]
-			qp.filterValue = filterParams[1]
+			qp.filterField = field
+			qp.filterValue = value
+		}
+	}
+
+	for _, attr := range values["attr"] {
+		name, value, err := parseFilter(attr)
+		if err != nil {
+			return err
+		}
+		qp.attributes = append(qp.attributes, attributeFilter{name: name, value: value})
+	}
+
+	for _, variant := range values["variant"] {
+		field, value, err := parseFilter(variant)
+		if err != nil {
+			return err
+		}
+		qp.variants = append(qp.variants, variantFilter{field: field, value: value})
+	}
+
+	facets, ok := values["facets"]
+	if ok {
+		if len(facets) > 0 && facets[0] != "" {
+			qp.facets = strings.Split(facets[0], ",")
+		}
+	}
+
+	if err := qp.fillPrice(values); err != nil {
+		return err
+	}
+
+	if inStock := values.Get("in_stock"); inStock != "" {
+		inStockVal, err := strconv.ParseBool(inStock)
+		if err != nil {
+			return errors.New("can't parse in_stock value")
+		}
+		qp.inStock = &inStockVal
+	}
+
+	qp.store = values.Get("store")
+
+	if err := qp.fillGeo(values); err != nil {
+		return err
+	}
+
+	if category := values.Get("category"); category != "" {
+		normalized, err := normalizeCategory(category)
+		if err != nil {
+			return err
+		}
+		qp.category = normalized
+	}
+
+	profile, ok := values["profile"]
+	if ok {
+		if len(profile) >= 1 {
+			qp.profile = profile[0]
 		}
 	}
 
@@ -81,11 +161,93 @@ func (qp *queryParams) fill(r *http.Request) error {
 	return nil
 }
 
-func (qp *queryParams) validate() error {
-	if qp.sortBy == "" {
-		qp.sortBy = "name"
+func (qp *queryParams) fillPrice(values url.Values) error {
+	var err error
+	if qp.priceMin, err = parsePrice(values.Get("price_min")); err != nil {
+		return err
+	}
+
+	if qp.priceMax, err = parsePrice(values.Get("price_max")); err != nil {
+		return err
+	}
+
+	qp.currency = values.Get("currency")
+
+	if interval := values.Get("price_interval"); interval != "" {
+		qp.priceInterval, err = strconv.ParseFloat(interval, 64)
+		if err != nil {
+			return errors.New("can't parse price_interval value")
+		}
+	}
+
+	if ranges := values.Get("price_ranges"); ranges != "" {
+		if qp.priceRanges, err = parsePriceRanges(ranges); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (qp *queryParams) fillGeo(values url.Values) error {
+	lat, lon := values.Get("lat"), values.Get("lon")
+	if lat != "" || lon != "" {
+		latVal, err := strconv.ParseFloat(lat, 64)
+		if err != nil {
+			return errors.New("can't parse lat value")
+		}
+
+		lonVal, err := strconv.ParseFloat(lon, 64)
+		if err != nil {
+			return errors.New("can't parse lon value")
+		}
+
+		qp.geo = &GeoPoint{Lat: latVal, Lon: lonVal}
+	}
+
+	if radius := values.Get("radius"); radius != "" {
+		parsed, err := parseRadius(radius)
+		if err != nil {
+			return err
+		}
+		qp.radius = parsed
+	}
+
+	return nil
+}
+
+// parseFilter splits filter in format field:value
+func parseFilter(filter string) (string, string, error) {
+	filterParams := strings.Split(filter, ":")
+	if len(filterParams) < 2 {
+		return "", "", errors.New("can't parse filter params, expect field:value")
 	}
 
+	return filterParams[0], filterParams[1], nil
+}
+
+// defaultSort orders by relevance when the query text, merchandising rules or
+// the search profile change scores, otherwise by name
+func (qp *queryParams) defaultSort(merch merchandising) string {
+	if qp.q != "" || len(merch.boost) > 0 || len(merch.bury) > 0 || qp.ranking.scores() {
+		return scoreSortField
+	}
+
+	return defaultSortField
+}
+
+// hasFacet reports whether the facet is requested
+func (qp *queryParams) hasFacet(name string) bool {
+	for _, facet := range qp.facets {
+		if facet == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (qp *queryParams) validate() error {
 	if qp.limit <= 0 {
 		qp.limit = 10
 	}
@@ -94,5 +256,100 @@ func (qp *queryParams) validate() error {
 		qp.offset = 0
 	}
 
+	if (qp.priceMin != nil || qp.priceMax != nil) && qp.currency == "" {
+		return errors.New("price_min and price_max require currency")
+	}
+
+	if qp.priceMin != nil && qp.priceMax != nil && *qp.priceMin > *qp.priceMax {
+		return errors.New("price_min should not be greater than price_max")
+	}
+
+	if qp.geo != nil {
+		if err := qp.geo.validate(); err != nil {
+			return err
+		}
+	}
+
+	if qp.geo == nil && (qp.radius != "" || qp.sortBy == distanceSortField) {
+		return errors.New("radius and distance sort require lat and lon")
+	}
+
+	if qp.priceInterval < 0 {
+		return errors.New("price_interval should be positive")
+	}
+
+	if qp.priceInterval == 0 {
+		qp.priceInterval = defaultPriceInterval
+	}
+
+	if qp.priceInterval < minPriceInterval {
+		return fmt.Errorf("price_interval should be at least %d", minPriceInterval)
+	}
+
+	for _, attr := range qp.attributes {
+		if !attributeNameRegexp.MatchString(attr.name) {
+			return fmt.Errorf("bad attribute name %q", attr.name)
+		}
+	}
+
+	for _, variant := range qp.variants {
+		if err := variant.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, facet := range qp.facets {
+		if !attributeNameRegexp.MatchString(facet) {
+			return fmt.Errorf("bad facet %q", facet)
+		}
+	}
+
 	return nil
 }
+
+// filters describes all filters of the search as query params like attr=color:red,
+// it's recorded to analytics
+func (qp *queryParams) filters() []string {
+	filters := []string{}
+	if qp.filterField != "" {
+		filters = append(filters, "filter="+qp.filterField+":"+qp.filterValue)
+	}
+
+	for _, attr := range qp.attributes {
+		filters = append(filters, "attr="+attr.name+":"+attr.value)
+	}
+
+	for _, variant := range qp.variants {
+		filters = append(filters, "variant="+variant.field+":"+variant.value)
+	}
+
+	if qp.priceMin != nil {
+		filters = append(filters, "price_min="+strconv.FormatFloat(*qp.priceMin, 'f', -1, 64))
+	}
+
+	if qp.priceMax != nil {
+		filters = append(filters, "price_max="+strconv.FormatFloat(*qp.priceMax, 'f', -1, 64))
+	}
+
+	if qp.currency != "" {
+		filters = append(filters, "currency="+qp.currency)
+	}
+
+	if qp.category != "" {
+		filters = append(filters, "category="+qp.category)
+	}
+
+	if qp.inStock != nil {
+		filters = append(filters, "in_stock="+strconv.FormatBool(*qp.inStock))
+	}
+
+	if qp.store != "" {
+		filters = append(filters, "store="+qp.store)
+	}
+
+	if qp.radius != "" {
+		filters = append(filters, "radius="+qp.radius)
+	}
+
+	return filters
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// indexVersionSeparator separates the alias and the version of the physical index,
+// documents alias points to one of documents_v1, documents_v2...
+const indexVersionSeparator = "_v"
+
+// ReindexResult describes the alias swap
+type ReindexResult struct {
+	Alias string `json:"alias"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int64  `json:"count"`
+}
+
+// versionedIndex returns the physical index name of the alias version
+func versionedIndex(alias string, version int) string {
+	return alias + indexVersionSeparator + strconv.Itoa(version)
+}
+
+// indexVersion returns the version of the physical index of the alias,
+// 0 means the index is not a version of the alias
+func indexVersion(alias, index string) int {
+	prefix := alias + indexVersionSeparator
+	if !strings.HasPrefix(index, prefix) {
+		return 0
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(index, prefix))
+	if err != nil || version <= 0 {
+		return 0
+	}
+
+	return version
+}
+
+// aliasIndex returns the physical index the alias points to
+func (ee *elasticEngine) aliasIndex(alias string) (string, error) {
+	result, err := ee.client.Aliases().Index(alias).Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return "", errNotFound
+	}
+	if err != nil {
+		return "", wrapper.Wrap(err, "error while get alias")
+	}
+
+	indices := result.IndicesByAlias(alias)
+	if len(indices) == 0 {
+		return "", errNotFound
+	}
+
+	sort.Slice(indices, func(i, j int) bool {
+		return indexVersion(alias, indices[i]) > indexVersion(alias, indices[j])
+	})
+
+	return indices[0], nil
+}
+
+// aliasVersions returns existing physical indices of the alias ordered by version
+func (ee *elasticEngine) aliasVersions(alias string) ([]string, error) {
+	names, err := ee.client.IndexNames()
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get index names")
+	}
+
+	indices := []string{}
+	for _, name := range names {
+		if indexVersion(alias, name) > 0 {
+			indices = append(indices, name)
+		}
+	}
+
+	sort.Slice(indices, func(i, j int) bool {
+		return indexVersion(alias, indices[i]) < indexVersion(alias, indices[j])
+	})
+
+	return indices, nil
+}
+
+// nextVersion returns the name of the next physical index of the alias
+func (ee *elasticEngine) nextVersion(alias string) (string, error) {
+	indices, err := ee.aliasVersions(alias)
+	if err != nil {
+		return "", err
+	}
+
+	version := 1
+	if len(indices) > 0 {
+		version = indexVersion(alias, indices[len(indices)-1]) + 1
+	}
+
+	return versionedIndex(alias, version), nil
+}
+
+// ensureAlias creates the first version of the index behind the alias, an index
+// created before versioning is kept as is until it's reindexed
+func (ee *elasticEngine) ensureAlias(alias, mapping string) error {
+	_, err := ee.aliasIndex(alias)
+	if err == nil {
+		return nil
+	}
+	if err != errNotFound {
+		return err
+	}
+
+	exists, err := ee.client.IndexExists(alias).Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while check index exists")
+	}
+	if exists {
+		log.Warnf("index %s is not versioned, reindex it to put it behind alias", alias)
+		return nil
+	}
+
+	index, err := ee.nextVersion(alias)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ee.client.CreateIndex(index).Body(mapping).Do(context.Background()); err != nil {
+		return wrapper.Wrap(err, "error while create index")
+	}
+
+	if _, err := ee.client.Alias().Add(index, alias).Do(context.Background()); err != nil {
+		return wrapper.Wrap(err, "error while add alias")
+	}
+
+	return nil
+}
+
+// reindex copies documents of the collection to the new index version with the mapping,
+// checks that no documents were lost and atomically points the alias to the new index.
+// Writes to the source index are blocked until the alias is swapped, so no changes are lost,
+// writes made while reindex is running fail and have to be retried by clients.
+// The previous version is kept for rollback, the new index is removed on failure.
+// The index created before versioning has the name of the alias, it's cloned to the first
+// version with its own mapping and then deleted, alias can't have the name of an index
+func (ee *elasticEngine) reindex(mapping string) (ReindexResult, error) {
+	alias := ee.index
+	source, err := ee.aliasIndex(alias)
+	legacy := err == errNotFound
+	if legacy {
+		source = alias
+	} else if err != nil {
+		return ReindexResult{}, err
+	}
+
+	if err := ee.blockWrites(source, true); err != nil {
+		return ReindexResult{}, err
+	}
+
+	// the legacy index is deleted by the swap, the previous version is unblocked for rollback
+	swapped := false
+	defer func() {
+		if legacy && swapped {
+			return
+		}
+		if err := ee.blockWrites(source, false); err != nil {
+			log.Errorf("error while unblock writes of index %s: %v", source, err)
+		}
+	}()
+
+	if legacy {
+		if err := ee.cloneLegacy(alias); err != nil {
+			return ReindexResult{}, err
+		}
+	}
+
+	target, err := ee.nextVersion(alias)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	if _, err := ee.client.CreateIndex(target).Body(mapping).Do(context.Background()); err != nil {
+		return ReindexResult{}, wrapper.Wrap(err, "error while create index")
+	}
+
+	count, err := ee.copyIndex(source, target)
+	if err != nil {
+		if _, deleteErr := ee.client.DeleteIndex(target).Do(context.Background()); deleteErr != nil {
+			log.Errorf("error while delete index %s: %v", target, deleteErr)
+		}
+		return ReindexResult{}, err
+	}
+
+	// the legacy index is removed in the same request, its clone is kept for rollback
+	var remove elastic.AliasAction = elastic.NewAliasRemoveAction(alias).Index(source)
+	if legacy {
+		remove = elastic.NewAliasRemoveIndexAction(source)
+	}
+
+	_, err = ee.client.Alias().
+		Action(remove, elastic.NewAliasAddAction(alias).Index(target)).
+		Do(context.Background())
+	if err != nil {
+		return ReindexResult{}, wrapper.Wrap(err, "error while swap alias")
+	}
+	swapped = true
+
+	return ReindexResult{Alias: alias, From: source, To: target, Count: count}, nil
+}
+
+// blockWrites sets or removes the write block of the index
+func (ee *elasticEngine) blockWrites(index string, blocked bool) error {
+	_, err := ee.client.IndexPutSettings(index).
+		BodyJson(map[string]interface{}{"index.blocks.write": blocked}).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrapf(err, "error while set write block of index %s", index)
+	}
+
+	return nil
+}
+
+// cloneLegacy clones the write blocked index created before versioning to the next version,
+// the clone keeps mapping and settings of the legacy index, so rollback restores it as is
+func (ee *elasticEngine) cloneLegacy(alias string) error {
+	backup, err := ee.nextVersion(alias)
+	if err != nil {
+		return err
+	}
+
+	_, err = ee.client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/" + alias + "/_clone/" + backup,
+		Params: url.Values{"wait_for_active_shards": []string{"1"}},
+	})
+	if err != nil {
+		return wrapper.Wrap(err, "error while clone legacy index")
+	}
+
+	return ee.blockWrites(backup, false)
+}
+
+// copyIndex copies all documents and validates the count of documents in both indices
+func (ee *elasticEngine) copyIndex(source, target string) (int64, error) {
+	_, err := ee.client.Reindex().
+		SourceIndex(source).
+		DestinationIndex(target).
+		Script(elastic.NewScript(effectivePriceScript)).
+		WaitForCompletion(true).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return 0, wrapper.Wrap(err, "error while reindex")
+	}
+
+	sourceCount, err := ee.client.Count(source).Do(context.Background())
+	if err != nil {
+		return 0, wrapper.Wrap(err, "error while count source documents")
+	}
+
+	targetCount, err := ee.client.Count(target).Do(context.Background())
+	if err != nil {
+		return 0, wrapper.Wrap(err, "error while count target documents")
+	}
+
+	if sourceCount != targetCount {
+		return 0, fmt.Errorf("documents count mismatch: %s has %d, %s has %d", source, sourceCount, target, targetCount)
+	}
+
+	return targetCount, nil
+}
+
+// rollback points the alias back to the previous existing index version
+func (ee *elasticEngine) rollback() (ReindexResult, error) {
+	alias := ee.index
+	current, err := ee.aliasIndex(alias)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	indices, err := ee.aliasVersions(alias)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	previous := ""
+	for _, index := range indices {
+		if indexVersion(alias, index) < indexVersion(alias, current) {
+			previous = index
+		}
+	}
+
+	if previous == "" {
+		return ReindexResult{}, errors.New("no previous index version")
+	}
+
+	_, err = ee.client.Alias().
+		Action(
+			elastic.NewAliasRemoveAction(alias).Index(current),
+			elastic.NewAliasAddAction(alias).Index(previous),
+		).
+		Do(context.Background())
+	if err != nil {
+		return ReindexResult{}, wrapper.Wrap(err, "error while swap alias")
+	}
+
+	count, err := ee.client.Count(previous).Do(context.Background())
+	if err != nil {
+		return ReindexResult{}, wrapper.Wrap(err, "error while count documents")
+	}
+
+	return ReindexResult{Alias: alias, From: current, To: previous, Count: count}, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexVersion(t *testing.T) {
+	Convey("Check versioned index names", t, func() {
+		So(versionedIndex(elasticIndexName, 3), ShouldEqual, "documents_v3")
+		So(indexVersion(elasticIndexName, "documents_v3"), ShouldEqual, 3)
+		So(indexVersion(elasticIndexName, versionedIndex(elasticIndexName, 12)), ShouldEqual, 12)
+
+		So(indexVersion(elasticIndexName, elasticIndexName), ShouldEqual, 0)
+		So(indexVersion(elasticIndexName, "documents_v0"), ShouldEqual, 0)
+		So(indexVersion(elasticIndexName, "documents_vx"), ShouldEqual, 0)
+		So(indexVersion("products_shop", "products_shop_v1_v1"), ShouldEqual, 0)
+		So(indexVersion("products_shop", "products_shop_v1"), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tokenStoreElastic = "elastic"
+	tokenStoreMemory  = "memory"
+
+	revokedTokensIndexName = "revoked_tokens"
+
+	// revokedTokensPurgeInterval is how often expired entries are removed from the index
+	revokedTokensPurgeInterval = time.Hour
+	// revokedCacheTTL is how long revocation checks are read from the cache, tokens revoked
+	// by other instances are accepted by this instance at most that long
+	revokedCacheTTL = 5 * time.Second
+
+	revokedTokensMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"expires_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+// RevokedToken is the entry of the revocation list, it's needed only until the token expires
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// clientGenerationID is the revocation id of tokens of the client generation
+func clientGenerationID(client string, generation int) string {
+	return "client:" + client + ":" + strconv.Itoa(generation)
+}
+
+// revocationStore keeps ids of revoked tokens and refresh token families
+type revocationStore interface {
+	// revoke adds the id to the list, false means it was already revoked
+	revoke(id string, expiresAt time.Time) (bool, error)
+	revoked(id string) (bool, error)
+}
+
+func newRevocationStore(kind string, engine elasticEngine) (revocationStore, error) {
+	switch kind {
+	case tokenStoreMemory:
+		return newMemoryRevocations(), nil
+	case "", tokenStoreElastic:
+		return newElasticRevocations(engine)
+	default:
+		return nil, fmt.Errorf("unknown token store %q", kind)
+	}
+}
+
+// memoryRevocations keeps the list in memory, it's lost on restart
+// and intended for tests and single instance setups
+type memoryRevocations struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+}
+
+func newMemoryRevocations() *memoryRevocations {
+	return &memoryRevocations{tokens: map[string]time.Time{}}
+}
+
+func (mr *memoryRevocations) revoke(id string, expiresAt time.Time) (bool, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	now := time.Now()
+	for revokedID, revokedUntil := range mr.tokens {
+		if revokedUntil.Before(now) {
+			delete(mr.tokens, revokedID)
+		}
+	}
+
+	if _, ok := mr.tokens[id]; ok {
+		return false, nil
+	}
+
+	mr.tokens[id] = expiresAt
+	return true, nil
+}
+
+func (mr *memoryRevocations) revoked(id string) (bool, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	_, ok := mr.tokens[id]
+	return ok, nil
+}
+
+// elasticRevocations stores the list in the separate elastic index shared by all instances,
+// checks are cached for revokedCacheTTL and expired entries are purged in background
+type elasticRevocations struct {
+	client *elastic.Client
+	cache  *ttlCache
+}
+
+func newElasticRevocations(engine elasticEngine) (*elasticRevocations, error) {
+	if err := engine.ensureIndex(revokedTokensIndexName, revokedTokensMapping); err != nil {
+		return nil, wrapper.Wrap(err, "error while create revoked tokens index")
+	}
+
+	er := &elasticRevocations{client: engine.client, cache: newTTLCache(revokedCacheTTL)}
+	go er.purgeExpired(revokedTokensPurgeInterval)
+
+	return er, nil
+}
+
+// purgeExpired removes expired entries every interval, they aren't needed
+// because expired tokens are rejected anyway
+func (er *elasticRevocations) purgeExpired(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := er.purge(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (er *elasticRevocations) purge() error {
+	_, err := er.client.DeleteByQuery(revokedTokensIndexName).
+		Query(elastic.NewRangeQuery("expires_at").Lt(time.Now().UTC())).
+		ProceedOnVersionConflict().
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete expired revoked tokens")
+	}
+
+	return nil
+}
+
+func (er *elasticRevocations) revoke(id string, expiresAt time.Time) (bool, error) {
+	_, err := er.client.Index().
+		Index(revokedTokensIndexName).
+		Id(id).
+		OpType("create").
+		BodyJson(RevokedToken{ID: id, ExpiresAt: expiresAt.UTC()}).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapper.Wrap(err, "error while revoke token")
+	}
+
+	er.cache.set(id, true)
+	return true, nil
+}
+
+func (er *elasticRevocations) revoked(id string) (bool, error) {
+	if cached, ok := er.cache.get(id); ok {
+		return cached.(bool), nil
+	}
+
+	exists, err := er.client.Exists().
+		Index(revokedTokensIndexName).
+		Id(id).
+		Do(context.Background())
+	if err != nil {
+		return false, wrapper.Wrap(err, "error while check token revoked")
+	}
+
+	er.cache.set(id, exists)
+	return exists, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryRevocations(t *testing.T) {
+	Convey("Check revoked tokens are kept until they expire", t, func() {
+		store := newMemoryRevocations()
+
+		first, err := store.revoke("expired", time.Now().Add(-time.Minute))
+		So(err, ShouldBeNil)
+		So(first, ShouldBeTrue)
+
+		first, err = store.revoke("token", time.Now().Add(time.Minute))
+		So(err, ShouldBeNil)
+		So(first, ShouldBeTrue)
+
+		first, err = store.revoke("token", time.Now().Add(time.Minute))
+		So(err, ShouldBeNil)
+		So(first, ShouldBeFalse)
+
+		revoked, err := store.revoked("token")
+		So(err, ShouldBeNil)
+		So(revoked, ShouldBeTrue)
+
+		revoked, err = store.revoked("expired")
+		So(err, ShouldBeNil)
+		So(revoked, ShouldBeFalse)
+	})
+}
+
+func TestElasticRevocationsCache(t *testing.T) {
+	Convey("Check revocation checks are read from the cache", t, func() {
+		store := &elasticRevocations{cache: newTTLCache(time.Minute)}
+		store.cache.set("token", true)
+		store.cache.set("active", false)
+
+		revoked, err := store.revoked("token")
+		So(err, ShouldBeNil)
+		So(revoked, ShouldBeTrue)
+
+		revoked, err = store.revoked("active")
+		So(err, ShouldBeNil)
+		So(revoked, ShouldBeFalse)
+	})
+}
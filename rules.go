@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	rulesIndexName = "rules"
+	rulesMaxCount  = 1000
+	// rulesCacheTTL is how long rules are read from the cache on search,
+	// other instances apply changed rules after it
+	rulesCacheTTL = 10 * time.Second
+
+	boostWeight = 10.0
+	buryWeight  = 0.1
+
+	rulesMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"collection":{
+				"type":"keyword"
+			},
+			"pattern":{
+				"type":"keyword"
+			},
+			"start_at":{
+				"type":"date"
+			},
+			"end_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+func (r *Rule) validate() error {
+	if r.Pattern == "" {
+		return errors.New("pattern is empty")
+	}
+
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return errors.New("bad pattern")
+	}
+
+	for _, pin := range r.Pins {
+		if pin.ID == "" || pin.Position <= 0 {
+			return errors.New("pin should have id and position greater than 0")
+		}
+	}
+
+	if !r.StartAt.IsZero() && !r.EndAt.IsZero() && r.EndAt.Before(r.StartAt) {
+		return errors.New("end_at is before start_at")
+	}
+
+	return nil
+}
+
+// active reports whether the rule campaign is running at the moment,
+// zero start or end means the rule is not limited from that side
+func (r *Rule) active(now time.Time) bool {
+	if !r.StartAt.IsZero() && now.Before(r.StartAt) {
+		return false
+	}
+
+	if !r.EndAt.IsZero() && now.After(r.EndAt) {
+		return false
+	}
+
+	return true
+}
+
+// matches checks the search query against the rule pattern, pattern is
+// a case insensitive glob like "running shoe*"
+func (r *Rule) matches(q string) bool {
+	matched, err := path.Match(normalizeQuery(r.Pattern), normalizeQuery(q))
+	return err == nil && matched
+}
+
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// merchandising is the result of all rules applied to one search query
+type merchandising struct {
+	pins  []Pin
+	boost []string
+	bury  []string
+}
+
+func newMerchandising(rules []Rule) merchandising {
+	m := merchandising{}
+	pinned := map[string]bool{}
+
+	for _, rule := range rules {
+		for _, pin := range rule.Pins {
+			if pinned[pin.ID] {
+				continue
+			}
+
+			pinned[pin.ID] = true
+			m.pins = append(m.pins, pin)
+		}
+
+		m.boost = append(m.boost, rule.Boost...)
+		m.bury = append(m.bury, rule.Bury...)
+	}
+
+	sort.SliceStable(m.pins, func(i, j int) bool {
+		return m.pins[i].Position < m.pins[j].Position
+	})
+
+	return m
+}
+
+// found keeps only pins of found documents, so missing and filtered out
+// documents don't take positions of the page
+func (m merchandising) found(docs map[string]Doc) merchandising {
+	pins := []Pin{}
+	for _, pin := range m.pins {
+		if _, ok := docs[pin.ID]; ok {
+			pins = append(pins, pin)
+		}
+	}
+
+	m.pins = pins
+	return m
+}
+
+func (m merchandising) empty() bool {
+	return len(m.pins) == 0 && len(m.boost) == 0 && len(m.bury) == 0
+}
+
+// query boosts and buries products and excludes pinned ones, pinned products are
+// placed on their positions after the search
+func (m merchandising) query(q elastic.Query) elastic.Query {
+	if m.empty() {
+		return q
+	}
+
+	boolQuery := elastic.NewBoolQuery().Must(q)
+
+	if len(m.boost) > 0 {
+		boolQuery.Should(elastic.NewIdsQuery().Ids(m.boost...).Boost(boostWeight))
+	}
+
+	if len(m.pins) > 0 {
+		boolQuery.MustNot(elastic.NewIdsQuery().Ids(pinIDs(m.pins)...))
+	}
+
+	if len(m.bury) == 0 {
+		return boolQuery
+	}
+
+	return elastic.NewBoostingQuery().
+		Positive(boolQuery).
+		Negative(elastic.NewIdsQuery().Ids(m.bury...)).
+		NegativeBoost(buryWeight)
+}
+
+// page returns from and size for the organic search and the pins placed on the
+// requested page, pins before the page shift the organic results back
+func (m merchandising) page(offset, limit int) (int, int, []Pin) {
+	before := 0
+	inPage := []Pin{}
+
+	for _, pin := range m.pins {
+		switch {
+		case pin.Position <= offset:
+			before++
+		case pin.Position <= offset+limit:
+			inPage = append(inPage, pin)
+		}
+	}
+
+	return offset - before, limit - len(inPage), inPage
+}
+
+func pinIDs(pins []Pin) []string {
+	ids := make([]string, 0, len(pins))
+	for _, pin := range pins {
+		ids = append(ids, pin.ID)
+	}
+
+	return ids
+}
+
+// splice places pinned documents on their positions of the page, pinned
+// documents which are not found are skipped
+func (m merchandising) splice(offset int, organic []Doc, pins []Pin, pinned map[string]Doc) []Hit {
+	hits := make([]Hit, 0, len(organic)+len(pins))
+	for _, doc := range organic {
+		hits = append(hits, Hit{Doc: doc})
+	}
+
+	for _, pin := range pins {
+		doc, ok := pinned[pin.ID]
+		if !ok {
+			continue
+		}
+
+		i := pin.Position - offset - 1
+		if i > len(hits) {
+			i = len(hits)
+		}
+
+		hits = append(hits, Hit{})
+		copy(hits[i+1:], hits[i:])
+		hits[i] = Hit{Doc: doc, Promoted: true}
+	}
+
+	return hits
+}
+
+// putRule creates or replaces the rule of the collection, rules of other
+// collections can't be replaced
+func (ee *elasticEngine) putRule(rule Rule) (Rule, error) {
+	if rule.ID == "" {
+		rule.ID = uuid.New().String()
+	} else if _, err := ee.rule(rule.ID); err == errCollectionMismatch {
+		return Rule{}, errNotFound
+	} else if err != nil && err != errNotFound {
+		return Rule{}, err
+	}
+
+	rule.Collection = ee.collection
+	_, err := ee.client.Index().
+		Index(rulesIndexName).
+		Id(rule.ID).
+		BodyJson(rule).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return Rule{}, wrapper.Wrap(err, "error while put rule")
+	}
+
+	ee.rulesCache.delete(ee.collection)
+	return rule, nil
+}
+
+// rule returns the rule of the collection, errCollectionMismatch means
+// the rule exists in another collection
+func (ee *elasticEngine) rule(id string) (Rule, error) {
+	result, err := ee.client.Get().
+		Index(rulesIndexName).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return Rule{}, errNotFound
+	}
+	if err != nil {
+		return Rule{}, wrapper.Wrap(err, "error while get rule")
+	}
+
+	rule := Rule{}
+	if err := json.Unmarshal(result.Source, &rule); err != nil {
+		return Rule{}, wrapper.Wrap(err, "error while unmarshal rule")
+	}
+
+	if rule.collection() != ee.collection {
+		return Rule{}, errCollectionMismatch
+	}
+
+	return rule, nil
+}
+
+// collection returns the collection of the rule, rules created before
+// collections had rules belong to the default one
+func (r *Rule) collection() string {
+	if r.Collection == "" {
+		return defaultCollectionName
+	}
+
+	return r.Collection
+}
+
+// rules returns rules of the collection
+func (ee *elasticEngine) rules() ([]Rule, error) {
+	collection := elastic.NewBoolQuery().
+		Should(elastic.NewTermQuery("collection", ee.collection))
+	if ee.collection == defaultCollectionName {
+		collection.Should(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("collection")))
+	}
+
+	result, err := ee.client.Search().
+		Index(rulesIndexName).
+		Query(collection).
+		Size(rulesMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get rules")
+	}
+
+	rules := []Rule{}
+	for _, hit := range result.Hits.Hits {
+		rule := Rule{}
+		if err := json.Unmarshal(hit.Source, &rule); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal rule")
+		}
+
+		rule.Collection = rule.collection()
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (ee *elasticEngine) deleteRule(id string) error {
+	if _, err := ee.rule(id); err == errCollectionMismatch {
+		return errNotFound
+	} else if err != nil {
+		return err
+	}
+
+	_, err := ee.client.Delete().
+		Index(rulesIndexName).
+		Id(id).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete rule")
+	}
+
+	ee.rulesCache.delete(ee.collection)
+	return nil
+}
+
+// cachedRules returns rules of the collection read at most rulesCacheTTL ago,
+// so searches don't read the rules index every time
+func (ee *elasticEngine) cachedRules() ([]Rule, error) {
+	if cached, ok := ee.rulesCache.get(ee.collection); ok {
+		return cached.([]Rule), nil
+	}
+
+	rules, err := ee.rules()
+	if err != nil {
+		return nil, err
+	}
+
+	ee.rulesCache.set(ee.collection, rules)
+	return rules, nil
+}
+
+// merchandising collects active rules of the collection matching the query
+func (ee *elasticEngine) merchandising(q string) (merchandising, error) {
+	rules, err := ee.cachedRules()
+	if err != nil {
+		return merchandising{}, err
+	}
+
+	now := time.Now().UTC()
+	matched := []Rule{}
+	for _, rule := range rules {
+		if rule.active(now) && rule.matches(q) {
+			matched = append(matched, rule)
+		}
+	}
+
+	return newMerchandising(matched), nil
+}
+
+// docsByIDs returns documents with given ids matching filters of params,
+// the query text is not applied, so pinned documents are found by any query
+func (ee *elasticEngine) docsByIDs(ids []string, params queryParams) (map[string]Doc, error) {
+	docs := map[string]Doc{}
+	if len(ids) == 0 {
+		return docs, nil
+	}
+
+	result, err := ee.client.Search().
+		Index(ee.index).
+		Query(addFilters(elastic.NewIdsQuery().Ids(ids...), params)).
+		Size(len(ids)).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get docs by ids")
+	}
+
+	found, err := docsFromHits(result)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range found {
+		docs[doc.ID] = doc
+	}
+
+	return docs, nil
+}
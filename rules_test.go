@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRuleMatches(t *testing.T) {
+	Convey("Check rule pattern matching", t, func() {
+		rule := Rule{Pattern: "Running shoe*"}
+
+		So(rule.matches("running shoes"), ShouldBeTrue)
+		So(rule.matches("  RUNNING   shoe "), ShouldBeTrue)
+		So(rule.matches("trail running shoes"), ShouldBeFalse)
+	})
+}
+
+func TestRuleActive(t *testing.T) {
+	Convey("Check rule campaign dates", t, func() {
+		now := time.Now()
+
+		So((&Rule{}).active(now), ShouldBeTrue)
+		So((&Rule{StartAt: now.Add(time.Hour)}).active(now), ShouldBeFalse)
+		So((&Rule{EndAt: now.Add(-time.Hour)}).active(now), ShouldBeFalse)
+		So((&Rule{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}).active(now), ShouldBeTrue)
+	})
+}
+
+func TestMerchandisingPins(t *testing.T) {
+	Convey("Check pinned products placement", t, func() {
+		merch := newMerchandising([]Rule{
+			Rule{Pins: []Pin{Pin{ID: "c", Position: 5}, Pin{ID: "a", Position: 1}}},
+			Rule{Pins: []Pin{Pin{ID: "a", Position: 3}, Pin{ID: "b", Position: 2}}},
+		})
+
+		from, size, pins := merch.page(0, 3)
+		So(from, ShouldEqual, 0)
+		So(size, ShouldEqual, 1)
+		So(len(pins), ShouldEqual, 2)
+
+		hits := merch.splice(0, []Doc{Doc{ID: "x"}}, pins, map[string]Doc{
+			"a": Doc{ID: "a"},
+			"b": Doc{ID: "b"},
+		})
+		So(len(hits), ShouldEqual, 3)
+		So(hits[0].ID, ShouldEqual, "a")
+		So(hits[0].Promoted, ShouldBeTrue)
+		So(hits[1].ID, ShouldEqual, "b")
+		So(hits[2].ID, ShouldEqual, "x")
+		So(hits[2].Promoted, ShouldBeFalse)
+
+		from, size, pins = merch.page(3, 3)
+		So(from, ShouldEqual, 1)
+		So(size, ShouldEqual, 2)
+		So(len(pins), ShouldEqual, 1)
+
+		hits = merch.splice(3, []Doc{Doc{ID: "y"}, Doc{ID: "z"}}, pins, map[string]Doc{
+			"c": Doc{ID: "c"},
+		})
+		So(hits[0].ID, ShouldEqual, "y")
+		So(hits[1].ID, ShouldEqual, "c")
+		So(hits[2].ID, ShouldEqual, "z")
+	})
+}
+
+func TestMerchandisingFound(t *testing.T) {
+	Convey("Check pins of missing documents are dropped", t, func() {
+		merch := newMerchandising([]Rule{
+			Rule{Pins: []Pin{Pin{ID: "a", Position: 1}, Pin{ID: "b", Position: 2}}},
+		}).found(map[string]Doc{"b": Doc{ID: "b"}})
+
+		So(len(merch.pins), ShouldEqual, 1)
+		So(merch.pins[0].ID, ShouldEqual, "b")
+	})
+}
+
+func TestDefaultSort(t *testing.T) {
+	Convey("Check search is sorted by score when scores matter", t, func() {
+		So((&queryParams{}).defaultSort(merchandising{}), ShouldEqual, defaultSortField)
+		So((&queryParams{q: "shoe"}).defaultSort(merchandising{}), ShouldEqual, scoreSortField)
+		So((&queryParams{}).defaultSort(merchandising{boost: []string{"a"}}), ShouldEqual, scoreSortField)
+		So((&queryParams{}).defaultSort(merchandising{pins: []Pin{Pin{ID: "a", Position: 1}}}), ShouldEqual, defaultSortField)
+	})
+}
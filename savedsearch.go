@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	savedSearchesIndexName = "saved_searches"
+	savedSearchesMaxCount  = 1000
+)
+
+// SavedSearch is a search registered to be notified about new matching products
+// of the collection, q and filter have the same format as the search query params.
+// Owner is the client which saved the search
+type SavedSearch struct {
+	ID         string `json:"id"`
+	Collection string `json:"collection"`
+	Owner      string `json:"owner"`
+	Q          string `json:"q"`
+	Filter     string `json:"filter,omitempty"`
+}
+
+// SavedSearchMatch is a payload of the saved search webhook
+type SavedSearchMatch struct {
+	SavedSearch SavedSearch `json:"saved_search"`
+	Product     Doc         `json:"product"`
+}
+
+// savedSearchDoc is stored in the percolator index
+type savedSearchDoc struct {
+	SavedSearch
+	Query interface{} `json:"query"`
+}
+
+func (ss *SavedSearch) validate() error {
+	if ss.Owner == "" {
+		return errors.New("token has no client to own the saved search")
+	}
+
+	if ss.Q == "" && ss.Filter == "" {
+		return errors.New("q or filter is required")
+	}
+
+	_, err := ss.params()
+	return err
+}
+
+func (ss *SavedSearch) params() (queryParams, error) {
+	params := queryParams{q: ss.Q}
+	if ss.Filter == "" {
+		return params, nil
+	}
+
+	field, value, err := parseFilter(ss.Filter)
+	if err != nil {
+		return queryParams{}, err
+	}
+
+	params.filterField = field
+	params.filterValue = value
+	return params, nil
+}
+
+// savedSearchesMapping is the documents mapping with the percolator field,
+// percolator needs all document fields used by queries to be mapped
+func savedSearchesMapping(documentsMapping string) (string, error) {
+	mapping := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(documentsMapping), &mapping); err != nil {
+		return "", wrapper.Wrap(err, "error while parse documents mapping")
+	}
+
+	mappings, ok := mapping["mappings"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("documents mapping has no mappings")
+	}
+
+	properties, ok := mappings["properties"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("documents mapping has no properties")
+	}
+
+	properties["query"] = map[string]interface{}{"type": "percolator"}
+	properties["collection"] = map[string]interface{}{"type": "keyword"}
+	properties["owner"] = map[string]interface{}{"type": "keyword"}
+	properties["q"] = map[string]interface{}{"type": "keyword"}
+	properties["filter"] = map[string]interface{}{"type": "keyword"}
+
+	body, err := json.Marshal(mapping)
+	if err != nil {
+		return "", wrapper.Wrap(err, "error while marshal saved searches mapping")
+	}
+
+	return string(body), nil
+}
+
+func (ee *elasticEngine) createSavedSearchesIndex() error {
+	mapping, err := savedSearchesMapping(ee.schema().Body)
+	if err != nil {
+		return err
+	}
+
+	return ee.ensureIndex(savedSearchesIndexName, mapping)
+}
+
+func (ee *elasticEngine) putSavedSearch(search SavedSearch) (SavedSearch, error) {
+	params, err := search.params()
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	query, err := searchQuery(params).Source()
+	if err != nil {
+		return SavedSearch{}, wrapper.Wrap(err, "error while build saved search query")
+	}
+
+	search.ID = uuid.New().String()
+	search.Collection = ee.collection
+	_, err = ee.client.Index().
+		Index(savedSearchesIndexName).
+		Id(search.ID).
+		BodyJson(savedSearchDoc{SavedSearch: search, Query: query}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return SavedSearch{}, wrapper.Wrap(err, "error while put saved search")
+	}
+
+	return search, nil
+}
+
+// savedSearches returns saved searches of the owner in the collection
+func (ee *elasticEngine) savedSearches(owner string) ([]SavedSearch, error) {
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("collection", ee.collection)).
+		Filter(elastic.NewTermQuery("owner", owner))
+
+	result, err := ee.client.Search().
+		Index(savedSearchesIndexName).
+		Query(query).
+		Size(savedSearchesMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get saved searches")
+	}
+
+	return savedSearchesFromHits(result)
+}
+
+// deleteSavedSearch removes saved search of the owner in the collection
+func (ee *elasticEngine) deleteSavedSearch(id, owner string) error {
+	result, err := ee.client.Get().
+		Index(savedSearchesIndexName).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while get saved search")
+	}
+
+	search := SavedSearch{}
+	if err := json.Unmarshal(result.Source, &search); err != nil {
+		return wrapper.Wrap(err, "error while unmarshal saved search")
+	}
+
+	if search.Collection != ee.collection || search.Owner != owner {
+		return errNotFound
+	}
+
+	_, err = ee.client.Delete().
+		Index(savedSearchesIndexName).
+		Id(id).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete saved search")
+	}
+
+	return nil
+}
+
+// percolate finds saved searches of the collection matching the document
+func (ee *elasticEngine) percolate(collection string, doc Doc) ([]SavedSearch, error) {
+	query := elastic.NewBoolQuery().
+		Must(elastic.NewPercolatorQuery().Field("query").Document(doc)).
+		Filter(elastic.NewTermQuery("collection", collection))
+
+	result, err := ee.client.Search().
+		Index(savedSearchesIndexName).
+		Query(query).
+		Size(savedSearchesMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while percolate document")
+	}
+
+	return savedSearchesFromHits(result)
+}
+
+func savedSearchesFromHits(result *elastic.SearchResult) ([]SavedSearch, error) {
+	searches := []SavedSearch{}
+	for _, hit := range result.Hits.Hits {
+		search := SavedSearch{}
+		if err := json.Unmarshal(hit.Source, &search); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal saved search")
+		}
+
+		searches = append(searches, search)
+	}
+
+	return searches, nil
+}
+
+type savedSearchJob struct {
+	collection string
+	docs       []Doc
+}
+
+// savedSearchNotifier percolates indexed documents and delivers matches to the webhook,
+// deliveries are signed by the secret like catalog events
+type savedSearchNotifier struct {
+	engine elasticEngine
+	sender *webhookSender
+	url    string
+	secret string
+	jobs   chan savedSearchJob
+}
+
+func newSavedSearchNotifier(engine elasticEngine, sender *webhookSender, url, secret string) (*savedSearchNotifier, error) {
+	if secret == "" {
+		return nil, errors.New("saved search webhook secret is empty")
+	}
+
+	n := &savedSearchNotifier{
+		engine: engine,
+		sender: sender,
+		url:    url,
+		secret: secret,
+		jobs:   make(chan savedSearchJob, webhookQueueSize),
+	}
+
+	for i := 0; i < webhookWorkers; i++ {
+		go n.work()
+	}
+
+	return n, nil
+}
+
+// notify is the index hook, created documents are percolated and delivered in background,
+// it never blocks the catalog change, so documents are dropped when the queue is full
+func (n *savedSearchNotifier) notify(collection, event string, docs []Doc) {
+	if event != docCreated {
+		return
+	}
+
+	select {
+	case n.jobs <- savedSearchJob{collection: collection, docs: docs}:
+	default:
+		log.Errorf("saved search queue is full, %d docs of collection %s are dropped", len(docs), collection)
+	}
+}
+
+func (n *savedSearchNotifier) work() {
+	for job := range n.jobs {
+		for _, doc := range job.docs {
+			searches, err := n.engine.percolate(job.collection, doc)
+			if err != nil {
+				log.Errorf("error while percolate doc %s: %v", doc.ID, err)
+				continue
+			}
+
+			for _, search := range searches {
+				if err := n.sender.deliver(n.url, n.secret, SavedSearchMatch{SavedSearch: search, Product: doc}); err != nil {
+					log.Errorf("error while deliver saved search %s match: %v", search.ID, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSavedSearchValidate(t *testing.T) {
+	Convey("Check saved search validation", t, func() {
+		So((&SavedSearch{Owner: "customer", Q: "shoe"}).validate(), ShouldBeNil)
+		So((&SavedSearch{Owner: "customer", Filter: "brand:bkatrenko"}).validate(), ShouldBeNil)
+		So((&SavedSearch{Q: "shoe"}).validate(), ShouldNotBeNil)
+		So((&SavedSearch{Owner: "customer"}).validate(), ShouldNotBeNil)
+		So((&SavedSearch{Owner: "customer", Filter: "brand"}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestSavedSearchesMapping(t *testing.T) {
+	Convey("Check saved searches mapping has documents fields", t, func() {
+		migrations, err := readMigrations(defaultMigrationsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mapping, err := savedSearchesMapping(migrations[len(migrations)-1].Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		So(mapping, ShouldContainSubstring, `"query":{"type":"percolator"}`)
+		So(mapping, ShouldContainSubstring, `"key_words":{"type":"keyword"}`)
+	})
+}
+
+func TestSavedSearchNotifier(t *testing.T) {
+	Convey("Check saved search deliveries require the secret and never block indexing", t, func() {
+		_, err := newSavedSearchNotifier(elasticEngine{}, newWebhookSender(), "http://localhost:9000/hook", "")
+		So(err, ShouldNotBeNil)
+
+		n := &savedSearchNotifier{jobs: make(chan savedSearchJob, 1)}
+		n.notify(defaultCollectionName, docCreated, []Doc{{ID: "1"}})
+		n.notify(defaultCollectionName, docCreated, []Doc{{ID: "2"}})
+		n.notify(defaultCollectionName, docUpdated, []Doc{{ID: "3"}})
+		So(len(n.jobs), ShouldEqual, 1)
+		So((<-n.jobs).docs[0].ID, ShouldEqual, "1")
+	})
+}
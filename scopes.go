@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	scopeProductsRead  = "products:read"
+	scopeProductsWrite = "products:write"
+	// scopeIntrospect allows services like the gateway to introspect tokens of other clients
+	scopeIntrospect = "tokens:introspect"
+	// scopeAdmin gives access to all routes
+	scopeAdmin = "admin"
+)
+
+// knownScopes are scopes which can be assigned to clients
+var knownScopes = []string{scopeProductsRead, scopeProductsWrite, scopeIntrospect, scopeAdmin}
+
+// defaultScopes are assigned to clients registered without scopes and to
+// clients registered before scopes were introduced
+var defaultScopes = []string{scopeProductsRead}
+
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !containsScope(knownScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	return nil
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasScope checks the token gives the scope, admin gives every scope
+func (tc tokenClaims) hasScope(scope string) bool {
+	return containsScope(tc.Info.Scopes, scope) || containsScope(tc.Info.Scopes, scopeAdmin)
+}
+
+// manages checks the token can manage resources of the tenant, admins bound
+// to the tenant manage only their tenant, admins without tenant manage all of them
+func (tc tokenClaims) manages(tenant string) bool {
+	return tc.Info.Tenant == "" || tc.Info.Tenant == tenant
+}
+
+// canGrant checks the admin of the token can give the tenant and scopes to clients
+// and api keys, so the admin can't issue credentials beyond its own
+func (tc tokenClaims) canGrant(tenant string, scopes []string) error {
+	if !tc.manages(tenant) {
+		return fmt.Errorf("tenant %q can't be granted by the token of tenant %q", tenant, tc.Info.Tenant)
+	}
+
+	for _, scope := range scopes {
+		if !tc.hasScope(scope) {
+			return fmt.Errorf("scope %q can't be granted by the token", scope)
+		}
+	}
+
+	return nil
+}
+
+// grantedScopes returns scopes of the client, the requested scope is space separated
+// as in RFC 6749 section 3.3 and should be a subset of client scopes
+func (c Client) grantedScopes(requested string) ([]string, error) {
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+
+	return requestScopes(scopes, requested)
+}
+
+// requestScopes returns the requested subset of allowed scopes, all of them if nothing is requested
+func requestScopes(allowed []string, requested string) ([]string, error) {
+	if requested == "" {
+		return allowed, nil
+	}
+
+	granted := []string{}
+	for _, scope := range strings.Fields(requested) {
+		if !containsScope(allowed, scope) {
+			return nil, fmt.Errorf("scope %q is not allowed for the client", scope)
+		}
+
+		if !containsScope(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	return granted, nil
+}
+
+// intersectScopes returns scopes which are in both lists
+func intersectScopes(a, b []string) []string {
+	scopes := []string{}
+	for _, scope := range a {
+		if containsScope(b, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
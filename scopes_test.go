@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGrantedScopes(t *testing.T) {
+	Convey("Check scopes granted to the client", t, func() {
+		c := Client{Scopes: []string{scopeProductsRead, scopeProductsWrite}}
+
+		scopes, err := c.grantedScopes("")
+		So(err, ShouldBeNil)
+		So(scopes, ShouldResemble, []string{scopeProductsRead, scopeProductsWrite})
+
+		scopes, err = c.grantedScopes("products:read products:read")
+		So(err, ShouldBeNil)
+		So(scopes, ShouldResemble, []string{scopeProductsRead})
+
+		_, err = c.grantedScopes("admin")
+		So(err, ShouldNotBeNil)
+
+		scopes, err = Client{}.grantedScopes("")
+		So(err, ShouldBeNil)
+		So(scopes, ShouldResemble, defaultScopes)
+
+		So(validateScopes([]string{"products:delete"}), ShouldNotBeNil)
+	})
+}
+
+func TestCanGrant(t *testing.T) {
+	Convey("Check admins grant only their tenant and scopes", t, func() {
+		admin := tokenClaims{Info: tokenInfo{Scopes: []string{scopeAdmin}}}
+		So(admin.manages("acme"), ShouldBeTrue)
+		So(admin.canGrant("acme", []string{scopeAdmin}), ShouldBeNil)
+
+		tenantAdmin := tokenClaims{Info: tokenInfo{Tenant: "acme", Scopes: []string{scopeAdmin}}}
+		So(tenantAdmin.manages("acme"), ShouldBeTrue)
+		So(tenantAdmin.manages(""), ShouldBeFalse)
+		So(tenantAdmin.canGrant("acme", []string{scopeProductsWrite}), ShouldBeNil)
+		So(tenantAdmin.canGrant("globex", []string{scopeProductsRead}), ShouldNotBeNil)
+		So(tenantAdmin.canGrant("", []string{scopeProductsRead}), ShouldNotBeNil)
+
+		writer := tokenClaims{Info: tokenInfo{Tenant: "acme", Scopes: []string{scopeProductsWrite}}}
+		So(writer.canGrant("acme", []string{scopeProductsWrite}), ShouldBeNil)
+		So(writer.canGrant("acme", []string{scopeAdmin}), ShouldNotBeNil)
+	})
+}
+
+func TestRequireScope(t *testing.T) {
+	Convey("Check routes are allowed only for tokens with the scope", t, func() {
+		h := apiHandler{}
+		handler := h.requireScope(scopeProductsWrite, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		serve := func(scopes ...string) int {
+			r := httptest.NewRequest("POST", "/v1/products", nil)
+			ctx := context.WithValue(r.Context(), claimsContextKey, tokenClaims{Info: tokenInfo{Scopes: scopes}})
+			rr := httptest.NewRecorder()
+			handler(rr, r.WithContext(ctx))
+			return rr.Code
+		}
+
+		So(serve(scopeProductsRead), ShouldEqual, http.StatusForbidden)
+		So(serve(), ShouldEqual, http.StatusForbidden)
+		So(serve(scopeProductsRead, scopeProductsWrite), ShouldEqual, http.StatusNoContent)
+		So(serve(scopeAdmin), ShouldEqual, http.StatusNoContent)
+
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", "/v1/products", nil))
+		So(rr.Code, ShouldEqual, http.StatusForbidden)
+	})
+}
@@ -38,7 +38,8 @@ func (s *instance) start() {
 
 func (s *instance) shutdown() {
 	if s.httpServer != nil {
-		ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 		err := s.httpServer.Shutdown(ctx)
 		if err != nil {
 			logrus.WithError(err).Error("Failed to shutdown http server gracefully")
@@ -46,4 +47,6 @@ func (s *instance) shutdown() {
 			s.httpServer = nil
 		}
 	}
+
+	s.handlers.close()
 }
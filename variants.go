@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+)
+
+const (
+	variantsField = "variants"
+	// variantsMaxCount is the max count of matching variants listed per product
+	variantsMaxCount = 100
+)
+
+// variantFilter matches products having a variant with the field value, all
+// variant filters of the search should match the same variant
+type variantFilter struct {
+	field string
+	value string
+}
+
+func (vf variantFilter) validate() error {
+	switch vf.field {
+	case "sku", "size", "color":
+		return nil
+	default:
+		return fmt.Errorf("unknown variant field %q", vf.field)
+	}
+}
+
+// validateVariants checks variants have unique SKU and no negative price or stock
+func validateVariants(variants []Variant) error {
+	skus := map[string]bool{}
+	for _, v := range variants {
+		if v.SKU == "" || skus[v.SKU] {
+			return errors.New("variant sku is empty or not unique")
+		}
+		skus[v.SKU] = true
+
+		if v.Price < 0 || v.Stock < 0 {
+			return fmt.Errorf("variant %q price and stock should not be negative", v.SKU)
+		}
+	}
+
+	return nil
+}
+
+// addVariantFilter narrows the query to products with a variant matching all filters,
+// matching variants are returned as inner hits
+func addVariantFilter(q elastic.Query, filters []variantFilter) elastic.Query {
+	if len(filters) == 0 {
+		return q
+	}
+
+	variantQuery := elastic.NewBoolQuery()
+	for _, filter := range filters {
+		variantQuery.Filter(elastic.NewTermQuery(variantsField+"."+filter.field, filter.value))
+	}
+
+	return elastic.NewBoolQuery().
+		Must(q).
+		Filter(elastic.NewNestedQuery(variantsField, variantQuery).
+			InnerHit(elastic.NewInnerHit().Name(variantsField).Size(variantsMaxCount)))
+}
+
+// matchingVariants returns variants matched by the variant filter per product id
+func matchingVariants(result *elastic.SearchResult) (map[string][]Variant, error) {
+	matching := map[string][]Variant{}
+	for _, hit := range result.Hits.Hits {
+		inner, ok := hit.InnerHits[variantsField]
+		if !ok || inner.Hits == nil {
+			continue
+		}
+
+		for _, variantHit := range inner.Hits.Hits {
+			variant := Variant{}
+			if err := json.Unmarshal(variantHit.Source, &variant); err != nil {
+				return nil, wrapper.Wrap(err, "error while unmarshal variant")
+			}
+
+			matching[hit.Id] = append(matching[hit.Id], variant)
+		}
+	}
+
+	return matching, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateVariants(t *testing.T) {
+	Convey("Check product variants validation", t, func() {
+		So((&Doc{Variants: []Variant{{SKU: "a-42", Size: "42"}, {SKU: "a-43", Size: "43", Stock: 1}}}).validate(), ShouldBeNil)
+		So((&Doc{Variants: []Variant{{SKU: "a-42"}, {SKU: "a-42"}}}).validate(), ShouldNotBeNil)
+		So((&Doc{Variants: []Variant{{Size: "42"}}}).validate(), ShouldNotBeNil)
+		So((&Doc{Variants: []Variant{{SKU: "a-42", Stock: -1}}}).validate(), ShouldNotBeNil)
+	})
+}
+
+func TestVariantFilter(t *testing.T) {
+	Convey("Check variant filters match the same variant", t, func() {
+		params := queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?variant=size:42&variant=color:red", nil)), ShouldBeNil)
+		So(params.validate(), ShouldBeNil)
+		So(params.variants, ShouldResemble, []variantFilter{{field: "size", value: "42"}, {field: "color", value: "red"}})
+
+		body := querySource(t, addVariantFilter(elastic.NewMatchAllQuery(), params.variants))
+		So(body, ShouldContainSubstring, `"nested":{"inner_hits":{"name":"variants","size":100},"path":"variants"`)
+		So(body, ShouldContainSubstring, `{"term":{"variants.size":"42"}},{"term":{"variants.color":"red"}}`)
+
+		params = queryParams{}
+		So(params.fill(httptest.NewRequest("GET", "/v1/products?variant=weight:1", nil)), ShouldBeNil)
+		So(params.validate(), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+	wrapper "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhooksIndexName    = "webhooks"
+	deadLettersIndexName = "webhook_dead_letters"
+	webhooksMaxCount     = 1000
+
+	webhookQueueSize = 1000
+	webhookWorkers   = 4
+
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	webhookTimeout  = 10 * time.Second
+
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+
+	webhooksMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"url":{
+				"type":"keyword"
+			},
+			"secret":{
+				"type":"keyword",
+				"index":false
+			},
+			"events":{
+				"type":"keyword"
+			},
+			"collection":{
+				"type":"keyword"
+			},
+			"created_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+
+	deadLettersMapping = `
+{
+	"settings":{
+		"number_of_shards":1,
+		"number_of_replicas":0
+	},
+	"mappings":{
+		"properties":{
+			"id":{
+				"type":"keyword"
+			},
+			"subscription_id":{
+				"type":"keyword"
+			},
+			"url":{
+				"type":"keyword"
+			},
+			"collection":{
+				"type":"keyword"
+			},
+			"event":{
+				"type":"object",
+				"enabled":false
+			},
+			"error":{
+				"type":"text"
+			},
+			"failed_at":{
+				"type":"date"
+			}
+		}
+	}
+}
+`
+)
+
+// WebhookSubscription receives catalog change events, empty events means all events
+// and empty collection means events of all collections.
+// Events are always signed, the secret is generated when it's not given
+type WebhookSubscription struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	Events     []string  `json:"events"`
+	Collection string    `json:"collection,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CatalogEvent is a payload of the catalog change webhook
+type CatalogEvent struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Collection string    `json:"collection"`
+	Time       time.Time `json:"time"`
+	Products   []Doc     `json:"products"`
+}
+
+// DeadLetter is a catalog event which was not delivered after all attempts
+type DeadLetter struct {
+	ID             string       `json:"id"`
+	SubscriptionID string       `json:"subscription_id"`
+	URL            string       `json:"url"`
+	Collection     string       `json:"collection"`
+	Event          CatalogEvent `json:"event"`
+	Error          string       `json:"error"`
+	FailedAt       time.Time    `json:"failed_at"`
+}
+
+func (ws *WebhookSubscription) validate() error {
+	parsed, err := url.Parse(ws.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url should be absolute http or https url")
+	}
+
+	for _, event := range ws.Events {
+		switch event {
+		case docCreated, docUpdated, docDeleted:
+		default:
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	return nil
+}
+
+func (ws *WebhookSubscription) subscribed(event string) bool {
+	if len(ws.Events) == 0 {
+		return true
+	}
+
+	for _, e := range ws.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// receives checks the subscription gets events of the collection
+func (ws *WebhookSubscription) receives(collection string) bool {
+	return ws.Collection == "" || ws.Collection == collection
+}
+
+type webhookJob struct {
+	subscription WebhookSubscription
+	event        CatalogEvent
+}
+
+// webhookDispatcher delivers catalog events to subscriptions asynchronously,
+// events which were not delivered or were dropped by full queues are stored as dead letters
+type webhookDispatcher struct {
+	engine        elasticEngine
+	sender        *webhookSender
+	events        chan CatalogEvent
+	jobs          chan webhookJob
+	putDeadLetter func(DeadLetter) error
+}
+
+func newWebhookDispatcher(engine elasticEngine, sender *webhookSender) *webhookDispatcher {
+	wd := &webhookDispatcher{
+		engine:        engine,
+		sender:        sender,
+		events:        make(chan CatalogEvent, webhookQueueSize),
+		jobs:          make(chan webhookJob, webhookQueueSize),
+		putDeadLetter: engine.putDeadLetter,
+	}
+
+	go wd.fanOut()
+	for i := 0; i < webhookWorkers; i++ {
+		go wd.work()
+	}
+
+	return wd
+}
+
+// notify is the index hook, it never blocks the catalog change
+func (wd *webhookDispatcher) notify(collection, event string, docs []Doc) {
+	catalogEvent := CatalogEvent{
+		ID:         uuid.New().String(),
+		Event:      event,
+		Collection: collection,
+		Time:       time.Now().UTC(),
+		Products:   docs,
+	}
+
+	// the event is dropped before subscriptions are known, so the dead letter has no subscription
+	select {
+	case wd.events <- catalogEvent:
+	default:
+		wd.deadLetter(WebhookSubscription{}, catalogEvent, errors.New("webhook event queue is full"))
+	}
+}
+
+// fanOut makes delivery jobs of events, it never waits for workers retrying deliveries,
+// jobs which don't fit the queue are stored as dead letters
+func (wd *webhookDispatcher) fanOut() {
+	for event := range wd.events {
+		subscriptions, err := wd.engine.webhooks()
+		if err != nil {
+			log.Errorf("error while get webhooks for event %s: %v", event.ID, err)
+			wd.deadLetter(WebhookSubscription{}, event, err)
+			continue
+		}
+
+		for _, subscription := range subscriptions {
+			if subscription.receives(event.Collection) && subscription.subscribed(event.Event) {
+				wd.dispatch(webhookJob{subscription: subscription, event: event})
+			}
+		}
+	}
+}
+
+func (wd *webhookDispatcher) dispatch(job webhookJob) {
+	select {
+	case wd.jobs <- job:
+	default:
+		wd.deadLetter(job.subscription, job.event, errors.New("webhook delivery queue is full"))
+	}
+}
+
+func (wd *webhookDispatcher) work() {
+	for job := range wd.jobs {
+		err := wd.sender.deliver(job.subscription.URL, job.subscription.Secret, job.event)
+		if err == nil {
+			continue
+		}
+
+		log.Errorf("error while deliver event %s to webhook %s: %v", job.event.ID, job.subscription.ID, err)
+		wd.deadLetter(job.subscription, job.event, err)
+	}
+}
+
+// deadLetter stores the event which was not delivered to the subscription
+func (wd *webhookDispatcher) deadLetter(subscription WebhookSubscription, event CatalogEvent, reason error) {
+	if err := wd.putDeadLetter(DeadLetter{
+		ID:             uuid.New().String(),
+		SubscriptionID: subscription.ID,
+		URL:            subscription.URL,
+		Collection:     event.Collection,
+		Event:          event,
+		Error:          reason.Error(),
+		FailedAt:       time.Now().UTC(),
+	}); err != nil {
+		log.Errorf("error while store dead letter of event %s: %v", event.ID, err)
+	}
+}
+
+func (ee *elasticEngine) putWebhook(subscription WebhookSubscription) (WebhookSubscription, error) {
+	subscription.ID = uuid.New().String()
+	subscription.CreatedAt = time.Now().UTC()
+
+	if subscription.Secret == "" {
+		secret, _, err := newClientSecret()
+		if err != nil {
+			return WebhookSubscription{}, wrapper.Wrap(err, "error while generate webhook secret")
+		}
+		subscription.Secret = secret
+	}
+
+	_, err := ee.client.Index().
+		Index(webhooksIndexName).
+		Id(subscription.ID).
+		BodyJson(subscription).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return WebhookSubscription{}, wrapper.Wrap(err, "error while put webhook")
+	}
+
+	return subscription, nil
+}
+
+func (ee *elasticEngine) webhooks() ([]WebhookSubscription, error) {
+	result, err := ee.client.Search().
+		Index(webhooksIndexName).
+		Query(elastic.NewMatchAllQuery()).
+		Size(webhooksMaxCount).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get webhooks")
+	}
+
+	subscriptions := []WebhookSubscription{}
+	for _, hit := range result.Hits.Hits {
+		subscription := WebhookSubscription{}
+		if err := json.Unmarshal(hit.Source, &subscription); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal webhook")
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, nil
+}
+
+func (ee *elasticEngine) getWebhook(id string) (WebhookSubscription, error) {
+	result, err := ee.client.Get().
+		Index(webhooksIndexName).
+		Id(id).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return WebhookSubscription{}, errNotFound
+	}
+	if err != nil {
+		return WebhookSubscription{}, wrapper.Wrap(err, "error while get webhook")
+	}
+
+	subscription := WebhookSubscription{}
+	if err := json.Unmarshal(result.Source, &subscription); err != nil {
+		return WebhookSubscription{}, wrapper.Wrap(err, "error while unmarshal webhook")
+	}
+
+	return subscription, nil
+}
+
+func (ee *elasticEngine) deleteWebhook(id string) error {
+	_, err := ee.client.Delete().
+		Index(webhooksIndexName).
+		Id(id).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return wrapper.Wrap(err, "error while delete webhook")
+	}
+
+	return nil
+}
+
+func (ee *elasticEngine) putDeadLetter(letter DeadLetter) error {
+	_, err := ee.client.Index().
+		Index(deadLettersIndexName).
+		Id(letter.ID).
+		BodyJson(letter).
+		Do(context.Background())
+	if err != nil {
+		return wrapper.Wrap(err, "error while put dead letter")
+	}
+
+	return nil
+}
+
+// deadLetters returns the latest dead letters, optionally of one subscription and collection
+func (ee *elasticEngine) deadLetters(subscriptionID, collection string, limit int) ([]DeadLetter, error) {
+	query := elastic.NewBoolQuery()
+	if subscriptionID != "" {
+		query.Filter(elastic.NewTermQuery("subscription_id", subscriptionID))
+	}
+	if collection != "" {
+		query.Filter(elastic.NewTermQuery("collection", collection))
+	}
+
+	result, err := ee.client.Search().
+		Index(deadLettersIndexName).
+		Query(query).
+		SortBy(elastic.NewFieldSort("failed_at").Desc()).
+		Size(limit).
+		Do(context.Background())
+	if err != nil {
+		return nil, wrapper.Wrap(err, "error while get dead letters")
+	}
+
+	letters := []DeadLetter{}
+	for _, hit := range result.Hits.Hits {
+		letter := DeadLetter{}
+		if err := json.Unmarshal(hit.Source, &letter); err != nil {
+			return nil, wrapper.Wrap(err, "error while unmarshal dead letter")
+		}
+
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// webhookSender posts JSON payloads to webhook receivers
+type webhookSender struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+func newWebhookSender() *webhookSender {
+	return &webhookSender{
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: webhookAttempts,
+		backoff:  webhookBackoff,
+	}
+}
+
+// deliver posts payload to url, failed attempts are retried with exponential backoff,
+// the error of the last attempt is returned when all of them failed.
+// Payload is signed with HMAC-SHA256 when secret is not empty, the signature covers
+// the timestamp of the attempt, so receivers reject old and replayed deliveries
+func (ws *webhookSender) deliver(url, secret string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return wrapper.Wrap(err, "error while marshal webhook payload")
+	}
+
+	backoff := ws.backoff
+	for attempt := 1; ; attempt++ {
+		err = ws.post(url, secret, body)
+		if err == nil || attempt >= ws.attempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (ws *webhookSender) post(url, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return wrapper.Wrap(err, "error while create webhook request")
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	if secret != "" {
+		req.Header.Set(signatureHeader, "sha256="+signPayload(secret, timestamp, body))
+	}
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return wrapper.Wrap(err, "error while post webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook receiver responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// signPayload returns hex encoded HMAC-SHA256 of the timestamp and the body joined by a dot,
+// receivers check it by the same function with the shared secret
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhookSubscription(t *testing.T) {
+	Convey("Check webhook subscription", t, func() {
+		subscription := WebhookSubscription{URL: "http://localhost:9000/hook"}
+		So(subscription.validate(), ShouldBeNil)
+		So(subscription.subscribed(docDeleted), ShouldBeTrue)
+
+		subscription.Events = []string{docCreated}
+		So(subscription.validate(), ShouldBeNil)
+		So(subscription.subscribed(docCreated), ShouldBeTrue)
+		So(subscription.subscribed(docUpdated), ShouldBeFalse)
+
+		subscription.Events = []string{"product.moved"}
+		So(subscription.validate(), ShouldNotBeNil)
+
+		So((&WebhookSubscription{URL: "localhost/hook"}).validate(), ShouldNotBeNil)
+
+		So(subscription.receives("acme"), ShouldBeTrue)
+		subscription.Collection = "acme"
+		So(subscription.receives("acme"), ShouldBeTrue)
+		So(subscription.receives(defaultCollectionName), ShouldBeFalse)
+	})
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	Convey("Check webhook delivery with retries", t, func() {
+		calls := 0
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}))
+		defer receiver.Close()
+
+		sender := newWebhookSender()
+		sender.backoff = time.Millisecond
+
+		So(sender.deliver(receiver.URL, "", Doc{Name: "shoe"}), ShouldBeNil)
+		So(calls, ShouldEqual, 3)
+
+		calls = 0
+		sender.attempts = 2
+		So(sender.deliver(receiver.URL, "", Doc{Name: "shoe"}), ShouldNotBeNil)
+		So(calls, ShouldEqual, 2)
+	})
+}
+
+func TestWebhookDispatcherQueues(t *testing.T) {
+	Convey("Check events dropped by full queues are stored as dead letters", t, func() {
+		letters := []DeadLetter{}
+		wd := &webhookDispatcher{
+			events: make(chan CatalogEvent),
+			jobs:   make(chan webhookJob),
+			putDeadLetter: func(letter DeadLetter) error {
+				letters = append(letters, letter)
+				return nil
+			},
+		}
+
+		wd.notify("acme", docCreated, []Doc{{ID: "1"}})
+		So(len(letters), ShouldEqual, 1)
+		So(letters[0].Collection, ShouldEqual, "acme")
+		So(letters[0].SubscriptionID, ShouldBeEmpty)
+		So(letters[0].Event.Products[0].ID, ShouldEqual, "1")
+
+		subscription := WebhookSubscription{ID: "hook", URL: "http://localhost:9000/hook"}
+		wd.dispatch(webhookJob{subscription: subscription, event: letters[0].Event})
+		So(len(letters), ShouldEqual, 2)
+		So(letters[1].SubscriptionID, ShouldEqual, "hook")
+		So(letters[1].Error, ShouldContainSubstring, "queue is full")
+	})
+}
+
+func TestWebhookSignature(t *testing.T) {
+	Convey("Check webhook payload signature", t, func() {
+		var signature, timestamp, expected string
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			signature = r.Header.Get(signatureHeader)
+			timestamp = r.Header.Get(signatureTimestampHeader)
+			expected = "sha256=" + signPayload("secret", timestamp, body)
+		}))
+		defer receiver.Close()
+
+		So(newWebhookSender().deliver(receiver.URL, "secret", CatalogEvent{Event: docCreated}), ShouldBeNil)
+		So(signature, ShouldNotBeEmpty)
+		So(timestamp, ShouldNotBeEmpty)
+		So(signature, ShouldEqual, expected)
+		So(signPayload("other", "1", []byte("body")), ShouldNotEqual, signPayload("secret", "1", []byte("body")))
+		So(signPayload("secret", "2", []byte("body")), ShouldNotEqual, signPayload("secret", "1", []byte("body")))
+	})
+}